@@ -0,0 +1,56 @@
+package main
+
+import "kiourin-studio/video-resolution/vr"
+
+// Exit statuses, so scripts can tell what went wrong without parsing the
+// output. They are listed in -help.
+const (
+	exitOK         = 0
+	exitFailed     = 1 // or a batch that failed in more than one way
+	exitUsage      = 2
+	exitDependency = 3
+	exitInput      = 4
+	exitProbe      = 5
+	exitEncoder    = 6
+	exitEncode     = 7
+	exitVerify     = 8
+	exitOutput     = 9
+	exitCancelled  = 130 // Ctrl+C or SIGTERM, as shells report for SIGINT
+)
+
+var exitCodes = map[vr.Code]int{
+	vr.MissingDependency:  exitDependency,
+	vr.InputError:         exitInput,
+	vr.ProbeError:         exitProbe,
+	vr.OutputError:        exitOutput,
+	vr.EncoderUnavailable: exitEncoder,
+	vr.EncodeFailed:       exitEncode,
+	vr.VerifyFailed:       exitVerify,
+	vr.Cancelled:          exitCancelled,
+}
+
+// exitStatus sums up a run: 0 when every job succeeded or was skipped on
+// purpose, the failure's own status when all failures agree, and
+// exitFailed when they do not. A cancelled run always ends with
+// exitCancelled.
+func exitStatus(results []vr.Result) int {
+	status := exitOK
+	for _, r := range results {
+		if r.Err == nil {
+			continue
+		}
+		if r.Code == vr.Cancelled {
+			return exitCancelled
+		}
+		code, ok := exitCodes[r.Code]
+		if !ok {
+			code = exitFailed
+		}
+		if status != exitOK && status != code {
+			status = exitFailed
+		} else {
+			status = code
+		}
+	}
+	return status
+}
@@ -0,0 +1,108 @@
+package batch
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type Item struct {
+	Path string
+	Rel  string
+}
+
+// Expand turns files, directories and glob patterns into a flat list of
+// inputs. Rel is the path below the directory an item was found in, so the
+// caller can mirror the source tree into an output directory. Arguments that
+// do not exist are kept, so they show up as failures instead of vanishing.
+func Expand(args []string, recursive bool, match func(string) bool) ([]Item, error) {
+	var items []Item
+	seen := map[string]bool{}
+
+	add := func(path, rel string) {
+		key, err := filepath.Abs(path)
+		if err != nil {
+			key = path
+		}
+		if seen[key] {
+			return
+		}
+		seen[key] = true
+		items = append(items, Item{Path: path, Rel: rel})
+	}
+
+	for _, arg := range args {
+		info, err := os.Stat(arg)
+		switch {
+		case err == nil && info.IsDir():
+			found, err := walk(arg, recursive, match)
+			if err != nil {
+				return nil, err
+			}
+			for _, f := range found {
+				rel, _ := filepath.Rel(arg, f)
+				add(f, rel)
+			}
+
+		case err == nil:
+			add(arg, filepath.Base(arg))
+
+		case IsPattern(arg):
+			matches, err := filepath.Glob(arg)
+			if err != nil {
+				return nil, err
+			}
+			root := patternRoot(arg)
+			for _, m := range matches {
+				if info, err := os.Stat(m); err != nil || info.IsDir() || !match(m) {
+					continue
+				}
+				rel, err := filepath.Rel(root, m)
+				if err != nil {
+					rel = filepath.Base(m)
+				}
+				add(m, rel)
+			}
+
+		default:
+			add(arg, filepath.Base(arg))
+		}
+	}
+
+	return items, nil
+}
+
+func IsPattern(arg string) bool {
+	return strings.ContainsAny(arg, "*?[")
+}
+
+func walk(root string, recursive bool, match func(string) bool) ([]string, error) {
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if path != root && !recursive {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasPrefix(d.Name(), ".") && match(path) {
+			files = append(files, path)
+		}
+		return nil
+	})
+	return files, err
+}
+
+// patternRoot is the longest leading directory of a glob pattern that
+// contains no wildcards.
+func patternRoot(pattern string) string {
+	dir := filepath.Dir(pattern)
+	for IsPattern(dir) {
+		dir = filepath.Dir(dir)
+	}
+	return dir
+}
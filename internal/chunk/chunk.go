@@ -0,0 +1,204 @@
+package chunk
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"kiourin-studio/video-resolution/internal/ffmpeg"
+	"kiourin-studio/video-resolution/internal/logger"
+	"kiourin-studio/video-resolution/internal/pipeline"
+)
+
+const (
+	minSegment = 10.0
+	retries    = 2
+)
+
+type Segment struct {
+	Index int
+	Start float64
+	End   float64
+	Path  string
+}
+
+func (s Segment) Length() float64 {
+	return s.End - s.Start
+}
+
+// Plan splits [0, duration) at keyframes into segments of roughly equal
+// length, a few per worker so a slow segment does not hold up the rest.
+// Cutting only at keyframes lets every segment start with a clean seek.
+// Keyframe times are relative to the start of the file, as -ss is.
+func Plan(keyframes []float64, duration float64, workers int) []Segment {
+	target := max(duration/float64(workers*2), minSegment)
+
+	var segments []Segment
+	start := 0.0
+	for _, k := range keyframes {
+		if k-start >= target && duration-k >= minSegment/2 {
+			segments = append(segments, Segment{Index: len(segments), Start: start, End: k})
+			start = k
+		}
+	}
+	return append(segments, Segment{Index: len(segments), Start: start, End: duration})
+}
+
+// Encode encodes the segments of base.Input concurrently with base's
+// encoder and filters, then joins them into base.Output with the original
+// audio. Failed segments are retried on their own before giving up.
+func Encode(ctx context.Context, base pipeline.Job, segments []Segment, workers int, stall time.Duration, log *logger.Logger, progress func(p ffmpeg.Progress)) error {
+	dir := Dir(base.Output)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+
+	segments = place(dir, segments)
+
+	var mu sync.Mutex
+	done := make([]ffmpeg.Progress, len(segments))
+	report := func(i int, p ffmpeg.Progress) {
+		mu.Lock()
+		defer mu.Unlock()
+		p.OutTime = min(p.OutTime, segments[i].Length())
+		if p.End {
+			p.FPS, p.Speed = 0, 0
+		}
+		done[i] = p
+
+		var total ffmpeg.Progress
+		for _, d := range done {
+			total.Frame += d.Frame
+			total.FPS += d.FPS
+			total.TotalSize += d.TotalSize
+			total.OutTime += d.OutTime
+			total.Speed += d.Speed
+		}
+		if total.OutTime > 0 {
+			total.Bitrate = float64(total.TotalSize) * 8 / 1000 / total.OutTime
+		}
+		progress(total)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	jobs := make(chan Segment)
+	errs := make(chan error, len(segments))
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for seg := range jobs {
+				var err error
+				for attempt := 0; attempt <= retries; attempt++ {
+					if attempt > 0 {
+						log.Info("Chunk", fmt.Sprintf("Retrying segment %d (%v)", seg.Index+1, err))
+					}
+					if err = encodeSegment(ctx, base, seg, stall, func(p ffmpeg.Progress) { report(seg.Index, p) }); err == nil || ctx.Err() != nil {
+						break
+					}
+				}
+				if err != nil {
+					errs <- fmt.Errorf("segment %d: %w", seg.Index+1, err)
+					cancel()
+				}
+			}
+		}()
+	}
+
+	for _, seg := range segments {
+		select {
+		case jobs <- seg:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+	close(errs)
+
+	if err := <-errs; err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	return concat(ctx, base, segments, dir, log)
+}
+
+// Commands lists the ffmpeg invocations Encode runs for segments: one per
+// segment, then the concat.
+func Commands(base pipeline.Job, segments []Segment) [][]string {
+	dir := Dir(base.Output)
+	var cmds [][]string
+	for _, seg := range place(dir, segments) {
+		cmds = append(cmds, pipeline.Args(segmentJob(base, seg)))
+	}
+	return append(cmds, pipeline.ConcatArgs(filepath.Join(dir, "segments.txt"), base.Input, base.Output))
+}
+
+func place(dir string, segments []Segment) []Segment {
+	placed := make([]Segment, len(segments))
+	for i, seg := range segments {
+		seg.Path = filepath.Join(dir, fmt.Sprintf("%05d.mp4", i))
+		placed[i] = seg
+	}
+	return placed
+}
+
+func segmentJob(base pipeline.Job, seg Segment) pipeline.Job {
+	j := base
+	j.Output = seg.Path
+	j.Seek = seg.Start
+	j.Length = seg.Length()
+	j.NoAudio = true
+	return j
+}
+
+// Dir is the directory holding the segments of output while it is
+// being encoded.
+func Dir(output string) string {
+	return output + ".chunks"
+}
+
+func encodeSegment(ctx context.Context, base pipeline.Job, seg Segment, stall time.Duration, progress func(p ffmpeg.Progress)) error {
+	var stderr strings.Builder
+	if err := ffmpeg.RunProgress(ctx, pipeline.Args(segmentJob(base, seg)), &stderr, stall, progress); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%w: %s", err, msg)
+		}
+		return err
+	}
+	return nil
+}
+
+func concat(ctx context.Context, base pipeline.Job, segments []Segment, dir string, log *logger.Logger) error {
+	var list strings.Builder
+	for _, seg := range segments {
+		path, err := filepath.Abs(seg.Path)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(&list, "file '%s'\n", strings.ReplaceAll(filepath.ToSlash(path), "'", `'\''`))
+	}
+
+	listPath := filepath.Join(dir, "segments.txt")
+	if err := os.WriteFile(listPath, []byte(list.String()), 0o644); err != nil {
+		return err
+	}
+
+	cmd := ffmpeg.Command(ctx, pipeline.ConcatArgs(listPath, base.Input, base.Output)...)
+	cmd.Stderr = log.Writer("FFmpeg", slog.LevelWarn)
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("concat failed: %w", err)
+	}
+	return nil
+}
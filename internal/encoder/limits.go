@@ -0,0 +1,39 @@
+package encoder
+
+import (
+	"strings"
+
+	"kiourin-studio/video-resolution/internal/scaler"
+)
+
+// Frame size limits of the H.264 encoders vr selects. Hardware limits are
+// the lowest common denominator across the generations still in use;
+// newer cards may accept more, but these never fail mid-run.
+var limits = map[string]scaler.Limits{
+	"h264_nvenc": {MinW: 146, MinH: 50, MaxW: 4096, MaxH: 4096, Align: 2},
+	"hevc_nvenc": {MinW: 130, MinH: 34, MaxW: 8192, MaxH: 8192, Align: 2},
+	"h264_qsv":   {MinW: 32, MinH: 32, MaxW: 4096, MaxH: 4096, Align: 2},
+	"hevc_qsv":   {MinW: 32, MinH: 32, MaxW: 8192, MaxH: 8192, Align: 2},
+	"h264_amf":   {MinW: 64, MinH: 64, MaxW: 4096, MaxH: 2176, Align: 2},
+	"hevc_amf":   {MinW: 192, MinH: 128, MaxW: 8192, MaxH: 4352, Align: 2},
+	"h264_vaapi": {MinW: 32, MinH: 32, MaxW: 4096, MaxH: 4096, Align: 2},
+	"hevc_vaapi": {MinW: 32, MinH: 32, MaxW: 8192, MaxH: 8192, Align: 2},
+	"libx264":    {MinW: 2, MinH: 2, MaxW: 16384, MaxH: 16384, Align: 2},
+}
+
+func LimitsFor(codec string) scaler.Limits {
+	if l, ok := limits[codec]; ok {
+		return l
+	}
+	if strings.HasPrefix(codec, "hevc") {
+		return limits["hevc_vaapi"]
+	}
+	return limits["h264_vaapi"]
+}
+
+func WithAlign(l scaler.Limits, align int) scaler.Limits {
+	if align > l.Align {
+		l.Align = align
+	}
+	return l
+}
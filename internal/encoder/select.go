@@ -1,6 +1,7 @@
 package encoder
 
 import (
+	"context"
 	"kiourin-studio/video-resolution/internal/ffmpeg"
 	"strings"
 )
@@ -10,21 +11,62 @@ type Config struct {
 	Params []string
 }
 
-func Auto(profile Profile) Config {
-	gpu := ffmpeg.DetectGPU()
+func Auto(ctx context.Context, profile Profile) Config {
+	return For(ctx, ffmpeg.DetectGPU(ctx), profile)
+}
 
+func For(ctx context.Context, gpu ffmpeg.GPU, profile Profile) Config {
 	switch gpu {
 	case ffmpeg.NVIDIA:
 		return nvidiaConfig(profile)
 	case ffmpeg.INTEL:
-		return intelConfig(profile)
+		return intelConfig(ctx, profile)
 	case ffmpeg.AMD:
-		return amdConfig(profile)
+		return amdConfig(ctx, profile)
 	default:
 		return cpuConfig(profile)
 	}
 }
 
+// Fallback is an encoder to try when another one fails, with the backend
+// it runs on.
+type Fallback struct {
+	GPU    ffmpeg.GPU
+	Config Config
+}
+
+// Fallbacks lists the encoders to try after codec failed, in order: NVENC,
+// QSV, AMF, VAAPI and libx264, starting after codec and leaving out the
+// ones FFmpeg does not have. VAAPI runs on Intel or AMD, whichever GPU the
+// machine has.
+func Fallbacks(ctx context.Context, codec string, profile Profile) []Fallback {
+	chain := []Fallback{
+		{ffmpeg.NVIDIA, nvidiaConfig(profile)},
+		{ffmpeg.INTEL, qsvConfig(profile)},
+		{ffmpeg.AMD, amfConfig(profile)},
+		{"", vaapiConfig(profile)},
+		{ffmpeg.CPU, cpuConfig(profile)},
+	}
+	for i, f := range chain {
+		if f.Config.Codec == codec {
+			chain = chain[i+1:]
+			break
+		}
+	}
+
+	var available []Fallback
+	for _, f := range chain {
+		if f.GPU != ffmpeg.CPU && !ffmpeg.HasEncoder(ctx, f.Config.Codec) {
+			continue
+		}
+		if f.GPU == "" {
+			f.GPU = ffmpeg.VAAPIGPU(ctx)
+		}
+		available = append(available, f)
+	}
+	return available
+}
+
 func ApplyCompression(config Config, gpu ffmpeg.GPU, profile Profile) Config {
 	newConfig := Config{
 		Codec:  config.Codec,
@@ -164,81 +206,72 @@ func nvidiaConfig(profile Profile) Config {
 	}
 }
 
-func intelConfig(profile Profile) Config {
-	if ffmpeg.HasEncoder("h264_qsv") {
-		switch profile {
-		case Low:
-			return Config{"h264_qsv", []string{
-				"-preset", "fast",
-				"-global_quality", "23",
-				"-look_ahead", "0",
-			}}
-		case High:
-			return Config{"h264_qsv", []string{
-				"-preset", "slow",
-				"-global_quality", "16",
-				"-look_ahead", "1",
-				"-extbrc", "1",
-			}}
-		default:
-			return Config{"h264_qsv", []string{
-				"-preset", "medium",
-				"-global_quality", "20",
-				"-look_ahead", "1",
-			}}
-		}
+func intelConfig(ctx context.Context, profile Profile) Config {
+	if ffmpeg.HasEncoder(ctx, "h264_qsv") {
+		return qsvConfig(profile)
+	}
+	return vaapiConfig(profile)
+}
+
+func amdConfig(ctx context.Context, profile Profile) Config {
+	if ffmpeg.HasEncoder(ctx, "h264_amf") {
+		return amfConfig(profile)
 	}
+	return vaapiConfig(profile)
+}
 
+func qsvConfig(profile Profile) Config {
 	switch profile {
 	case Low:
-		return Config{"h264_vaapi", []string{
-			"-compression_level", "1",
-			"-qp", "23",
+		return Config{"h264_qsv", []string{
+			"-preset", "fast",
+			"-global_quality", "23",
+			"-look_ahead", "0",
+		}}
+	case High:
+		return Config{"h264_qsv", []string{
+			"-preset", "slow",
+			"-global_quality", "16",
+			"-look_ahead", "1",
+			"-extbrc", "1",
+		}}
+	default:
+		return Config{"h264_qsv", []string{
+			"-preset", "medium",
+			"-global_quality", "20",
+			"-look_ahead", "1",
+		}}
+	}
+}
+
+func amfConfig(profile Profile) Config {
+	switch profile {
+	case Low:
+		return Config{"h264_amf", []string{
+			"-usage", "ultralowlatency",
 			"-quality", "speed",
+			"-qp_i", "23",
+			"-qp_p", "23",
 		}}
 	case High:
-		return Config{"h264_vaapi", []string{
-			"-compression_level", "7",
-			"-qp", "16",
+		return Config{"h264_amf", []string{
+			"-usage", "transcoding",
 			"-quality", "quality",
+			"-qp_i", "16",
+			"-qp_p", "16",
+			"-preanalysis", "1",
 		}}
 	default:
-		return Config{"h264_vaapi", []string{
-			"-compression_level", "3",
-			"-qp", "20",
+		return Config{"h264_amf", []string{
+			"-usage", "transcoding",
 			"-quality", "balanced",
+			"-qp_i", "20",
+			"-qp_p", "20",
 		}}
 	}
 }
 
-func amdConfig(profile Profile) Config {
-	if ffmpeg.HasEncoder("h264_amf") {
-		switch profile {
-		case Low:
-			return Config{"h264_amf", []string{
-				"-usage", "ultralowlatency",
-				"-quality", "speed",
-				"-qp_i", "23",
-				"-qp_p", "23",
-			}}
-		case High:
-			return Config{"h264_amf", []string{
-				"-usage", "transcoding",
-				"-quality", "quality",
-				"-qp_i", "16",
-				"-qp_p", "16",
-				"-preanalysis", "1",
-			}}
-		default:
-			return Config{"h264_amf", []string{
-				"-usage", "transcoding",
-				"-quality", "balanced",
-				"-qp_i", "20",
-				"-qp_p", "20",
-			}}
-		}
-	}
-
+func vaapiConfig(profile Profile) Config {
 	switch profile {
 	case Low:
 		return Config{"h264_vaapi", []string{
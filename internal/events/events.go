@@ -0,0 +1,40 @@
+package events
+
+import (
+	"encoding/json"
+	"io"
+	"sync"
+	"time"
+)
+
+// Writer writes events as newline-delimited JSON, one object per line.
+// It is safe for concurrent jobs, and a nil Writer drops everything.
+type Writer struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+type event struct {
+	Type  string    `json:"type"`
+	Time  time.Time `json:"time"`
+	Input string    `json:"input,omitempty"`
+	Data  any       `json:"data,omitempty"`
+}
+
+type Message struct {
+	Message string `json:"message"`
+	Code    string `json:"code,omitempty"`
+}
+
+func New(w io.Writer) *Writer {
+	return &Writer{enc: json.NewEncoder(w)}
+}
+
+func (w *Writer) Emit(typ, input string, data any) {
+	if w == nil {
+		return
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.enc.Encode(event{Type: typ, Time: time.Now(), Input: input, Data: data})
+}
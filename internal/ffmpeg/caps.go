@@ -0,0 +1,263 @@
+package ffmpeg
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// Capabilities is what the ffmpeg binary was built with. It is read once
+// per binary and shared, so it must not be modified.
+type Capabilities struct {
+	Encoders map[string]Codec  `json:"encoders"`
+	Decoders map[string]Codec  `json:"decoders"`
+	Filters  map[string]Filter `json:"filters"`
+	HWAccels []string          `json:"hwaccels"`
+}
+
+type Codec struct {
+	Type        string `json:"type"` // video, audio or subtitle
+	Description string `json:"description"`
+}
+
+type Filter struct {
+	IO          string `json:"io"` // e.g. "V->V"
+	Description string `json:"description"`
+}
+
+func (c *Capabilities) HasEncoder(name string) bool {
+	_, ok := c.Encoders[name]
+	return ok
+}
+
+func (c *Capabilities) HasDecoder(name string) bool {
+	_, ok := c.Decoders[name]
+	return ok
+}
+
+func (c *Capabilities) HasFilter(name string) bool {
+	_, ok := c.Filters[name]
+	return ok
+}
+
+func (c *Capabilities) HasHWAccel(name string) bool {
+	for _, h := range c.HWAccels {
+		if h == name {
+			return true
+		}
+	}
+	return false
+}
+
+// binary identifies an ffmpeg build. A new build at the same path has a
+// different size or modification time.
+type binary struct {
+	Path    string `json:"path"`
+	Size    int64  `json:"size"`
+	ModTime int64  `json:"mtime"`
+}
+
+var caps struct {
+	mu   sync.Mutex
+	bin  binary
+	caps *Capabilities
+	err  error
+}
+
+// Caps returns the capabilities of the ffmpeg on PATH. FFmpeg is asked
+// only the first time, or when the binary changed; the answer is kept in
+// memory and in the cache file (see CacheFile). A failure is kept in
+// memory only, so the next run asks again.
+func Caps(ctx context.Context) (*Capabilities, error) {
+	bin, err := currentBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	caps.mu.Lock()
+	defer caps.mu.Unlock()
+	if caps.bin == bin && (caps.caps != nil || caps.err != nil) {
+		return caps.caps, caps.err
+	}
+
+	c, ok := loadCaps(bin)
+	if !ok {
+		c, err = queryCaps(ctx)
+		if err != nil {
+			// A broken FFmpeg is not run again for every check in a batch.
+			// A query that was cancelled says nothing about FFmpeg.
+			if ctx.Err() != context.Canceled {
+				caps.bin, caps.caps, caps.err = bin, nil, err
+			}
+			return nil, err
+		}
+		saveCaps(bin, c)
+	}
+	caps.bin, caps.caps, caps.err = bin, c, nil
+	return c, nil
+}
+
+func currentBinary() (binary, error) {
+	path, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return binary{}, err
+	}
+	if abs, err := filepath.Abs(path); err == nil {
+		path = abs
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return binary{}, err
+	}
+	return binary{Path: path, Size: info.Size(), ModTime: info.ModTime().UnixNano()}, nil
+}
+
+func queryCaps(ctx context.Context) (*Capabilities, error) {
+	encoders, err := query(ctx, "-hide_banner", "-encoders")
+	if err != nil {
+		return nil, err
+	}
+	decoders, err := query(ctx, "-hide_banner", "-decoders")
+	if err != nil {
+		return nil, err
+	}
+	filters, err := query(ctx, "-hide_banner", "-filters")
+	if err != nil {
+		return nil, err
+	}
+	hwaccels, err := query(ctx, "-hide_banner", "-hwaccels")
+	if err != nil {
+		return nil, err
+	}
+	return &Capabilities{
+		Encoders: parseCodecs(string(encoders)),
+		Decoders: parseCodecs(string(decoders)),
+		Filters:  parseFilters(string(filters)),
+		HWAccels: parseHWAccels(string(hwaccels)),
+	}, nil
+}
+
+// parseCodecs reads the list of -encoders or -decoders: a legend, a line
+// of dashes, then one "V..... name  description" line per codec.
+func parseCodecs(out string) map[string]Codec {
+	codecs := map[string]Codec{}
+	listed := false
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.HasPrefix(line, "---") {
+			listed = true
+			continue
+		}
+		fields := strings.Fields(line)
+		if !listed || len(fields) < 2 {
+			continue
+		}
+		typ := map[byte]string{'V': "video", 'A': "audio", 'S': "subtitle"}[fields[0][0]]
+		codecs[fields[1]] = Codec{
+			Type:        typ,
+			Description: strings.Join(fields[2:], " "),
+		}
+	}
+	return codecs
+}
+
+// parseFilters reads -filters, where each filter line is "T.. name A->V
+// description". The legend has no "->" column.
+func parseFilters(out string) map[string]Filter {
+	filters := map[string]Filter{}
+	for _, line := range strings.Split(out, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 || !strings.Contains(fields[2], "->") {
+			continue
+		}
+		filters[fields[1]] = Filter{
+			IO:          fields[2],
+			Description: strings.Join(fields[3:], " "),
+		}
+	}
+	return filters
+}
+
+func parseHWAccels(out string) []string {
+	var hwaccels []string
+	for _, line := range strings.Split(out, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasSuffix(line, ":") {
+			continue
+		}
+		hwaccels = append(hwaccels, line)
+	}
+	return hwaccels
+}
+
+// CacheFile is where capabilities are cached between runs: vr/ffmpeg.json
+// in the user cache directory, or VR_CACHE/ffmpeg.json. VR_CACHE=off turns
+// the cache off and CacheFile returns "".
+func CacheFile() string {
+	dir := os.Getenv("VR_CACHE")
+	switch dir {
+	case "off":
+		return ""
+	case "":
+		base, err := os.UserCacheDir()
+		if err != nil {
+			return ""
+		}
+		dir = filepath.Join(base, "vr")
+	}
+	return filepath.Join(dir, "ffmpeg.json")
+}
+
+type cachedCaps struct {
+	Binary       binary        `json:"binary"`
+	Capabilities *Capabilities `json:"capabilities"`
+}
+
+func loadCaps(bin binary) (*Capabilities, bool) {
+	path := CacheFile()
+	if path == "" {
+		return nil, false
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+	var cached cachedCaps
+	if json.Unmarshal(data, &cached) != nil || cached.Binary != bin || cached.Capabilities == nil {
+		return nil, false
+	}
+	return cached.Capabilities, true
+}
+
+// saveCaps writes the cache file. A cache that cannot be written only
+// costs the next run a few FFmpeg calls, so errors are ignored.
+func saveCaps(bin binary, c *Capabilities) {
+	path := CacheFile()
+	if path == "" {
+		return
+	}
+	data, err := json.Marshal(cachedCaps{Binary: bin, Capabilities: c})
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(path), 0o755) != nil {
+		return
+	}
+	// Several vr processes may start at once, so each writes its own
+	// temporary file.
+	tmp, err := os.CreateTemp(filepath.Dir(path), "ffmpeg-*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil || os.Rename(tmp.Name(), path) != nil {
+		os.Remove(tmp.Name())
+	}
+}
@@ -0,0 +1,121 @@
+package ffmpeg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+// GraceTimeout is how long FFmpeg gets to finish up after it was asked to
+// stop, before it is interrupted, and again before it is killed.
+var GraceTimeout = 10 * time.Second
+
+var (
+	force     = make(chan struct{})
+	forceOnce sync.Once
+)
+
+// Command is exec.CommandContext for FFmpeg with a gentler cancel. When
+// ctx ends, FFmpeg gets a "q" on stdin so it can close its output, then an
+// interrupt after GraceTimeout, and is killed after another GraceTimeout
+// or as soon as ForceStop is called. A run that stalled is killed at once.
+func Command(ctx context.Context, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, "ffmpeg", args...)
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return cmd
+	}
+
+	cmd.Cancel = func() error {
+		if context.Cause(ctx) == ErrStalled {
+			return cmd.Process.Kill()
+		}
+		stdin.Write([]byte("q"))
+		go func() {
+			select {
+			case <-force:
+				cmd.Process.Kill()
+			case <-time.After(GraceTimeout):
+				// Windows cannot send an interrupt, so FFmpeg is killed
+				// instead of waiting out WaitDelay.
+				if err := cmd.Process.Signal(os.Interrupt); err != nil && !errors.Is(err, os.ErrProcessDone) {
+					cmd.Process.Kill()
+				}
+			}
+		}()
+		return nil
+	}
+	cmd.WaitDelay = 2 * GraceTimeout
+	return cmd
+}
+
+// ForceStop kills every FFmpeg that is already stopping.
+func ForceStop() {
+	forceOnce.Do(func() { close(force) })
+}
+
+// ErrStalled is returned for an FFmpeg that stopped making progress.
+var ErrStalled = errors.New("ffmpeg stopped making progress")
+
+// RunProgress runs FFmpeg with args, which must include "-progress
+// pipe:1", and hands each report to progress. With stall > 0, FFmpeg is
+// killed when neither its frame count, output time nor size has moved for
+// that long, and the error wraps ErrStalled.
+func RunProgress(ctx context.Context, args []string, stderr io.Writer, stall time.Duration, progress func(Progress)) error {
+	ctx, cancel := context.WithCancelCause(ctx)
+	defer cancel(nil)
+
+	cmd := Command(ctx, args...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	cmd.Stderr = stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	report := progress
+	if stall > 0 {
+		advanced := make(chan struct{}, 1)
+		var last Progress
+		report = func(p Progress) {
+			if p.Frame > last.Frame || p.OutTime > last.OutTime || p.TotalSize > last.TotalSize {
+				last = p
+				select {
+				case advanced <- struct{}{}:
+				default:
+				}
+			}
+			progress(p)
+		}
+
+		go func() {
+			timer := time.NewTimer(stall)
+			defer timer.Stop()
+			for {
+				select {
+				case <-advanced:
+					timer.Reset(stall)
+				case <-timer.C:
+					cancel(ErrStalled)
+					return
+				case <-ctx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	ReadProgress(stdout, report)
+	err = cmd.Wait()
+	if context.Cause(ctx) == ErrStalled {
+		return fmt.Errorf("%w for %s", ErrStalled, stall)
+	}
+	return err
+}
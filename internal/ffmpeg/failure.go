@@ -0,0 +1,142 @@
+package ffmpeg
+
+import (
+	"errors"
+	"os/exec"
+	"strings"
+	"sync"
+)
+
+// Failure says why an FFmpeg run failed, as far as its error output tells.
+type Failure string
+
+const (
+	NoSession    Failure = "no_session"    // the encoder has no free session
+	Unsupported  Failure = "unsupported"   // size or format the encoder cannot do
+	DriverError  Failure = "driver_error"  // device or driver cannot be used
+	EncoderError Failure = "encoder_error" // the encoder did not open
+	NotRun       Failure = "not_run"       // FFmpeg could not be started
+	Stalled      Failure = "stalled"       // FFmpeg stopped making progress
+	OtherFailure Failure = "other"
+)
+
+var failurePatterns = []struct {
+	failure  Failure
+	patterns []string
+}{
+	{NoSession, []string{
+		"out of sessions",
+		"openencodesessionex failed",
+		"no capable devices found",
+		"incompatible client key",
+		"too many sessions",
+	}},
+	{Unsupported, []string{
+		"frame dimension",
+		"resolution not supported",
+		"unsupported resolution",
+		"width not supported",
+		"height not supported",
+		"not supported by the hardware",
+		"exceeds the maximum",
+		"invalid width",
+		"invalid height",
+	}},
+	{DriverError, []string{
+		"cannot load lib",
+		"cuinit",
+		"cuda_error",
+		"driver does not support",
+		"failed to initialise vaapi",
+		"failed to initialize vaapi",
+		"error creating a mfx session",
+		"device creation failed",
+		"no device available",
+		"failed to create amf",
+	}},
+	{EncoderError, []string{
+		"error opening encoder",
+		"error while opening encoder",
+		"could not open encoder",
+		"error initializing output stream",
+		"unknown encoder",
+	}},
+}
+
+// Classify looks at a failed run's error and the end of its stderr. It
+// also returns the line that explains the failure best.
+func Classify(err error, stderr string) (Failure, string) {
+	if errors.Is(err, ErrStalled) {
+		return Stalled, err.Error()
+	}
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) {
+		return NotRun, err.Error()
+	}
+
+	lines := strings.Split(stderr+"\n"+err.Error(), "\n")
+	for _, fp := range failurePatterns {
+		for _, line := range lines {
+			lower := strings.ToLower(line)
+			for _, p := range fp.patterns {
+				if strings.Contains(lower, p) {
+					return fp.failure, strings.TrimSpace(line)
+				}
+			}
+		}
+	}
+
+	// FFmpeg's last words are often a generic "Conversion failed!", so
+	// the line before says more.
+	for _, text := range []string{stderr, err.Error()} {
+		lines := strings.Split(text, "\n")
+		for i := len(lines) - 1; i >= 0; i-- {
+			if line := strings.TrimSpace(lines[i]); line != "" && !strings.EqualFold(line, "conversion failed!") {
+				return OtherFailure, line
+			}
+		}
+	}
+	return OtherFailure, err.Error()
+}
+
+// Hardware reports whether the failure lies with the encoder or the
+// device behind it, so another encoder may succeed.
+func (f Failure) Hardware() bool {
+	switch f {
+	case NoSession, Unsupported, DriverError, EncoderError:
+		return true
+	}
+	return false
+}
+
+// Tail keeps the last lines written to it, for FFmpeg's stderr.
+type Tail struct {
+	mu    sync.Mutex
+	lines []string
+	part  string
+}
+
+const tailLines = 20
+
+func (t *Tail) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	lines := strings.Split(t.part+string(p), "\n")
+	t.part = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		if line = strings.TrimSpace(line); line != "" {
+			t.lines = append(t.lines, line)
+		}
+	}
+	if len(t.lines) > tailLines {
+		t.lines = t.lines[len(t.lines)-tailLines:]
+	}
+	return len(p), nil
+}
+
+func (t *Tail) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return strings.Join(append(t.lines, t.part), "\n")
+}
@@ -1,6 +1,7 @@
 package ffmpeg
 
 import (
+	"context"
 	"os/exec"
 	"strings"
 )
@@ -14,160 +15,152 @@ const (
 	CPU    GPU = "cpu"
 )
 
-var (
-	forcedGPU GPU = ""
-)
-
-func DetectGPU() GPU {
-	if forcedGPU != "" {
-		return forcedGPU
-	}
+// query runs ffmpeg with args and returns what it printed. It gives up
+// when ctx ends, so a hung FFmpeg cannot block detection.
+func query(ctx context.Context, args ...string) ([]byte, error) {
+	return exec.CommandContext(ctx, "ffmpeg", args...).Output()
+}
 
-	cmd := exec.Command("ffmpeg", "-encoders")
-	out, err := cmd.Output()
+// encoders reports whether FFmpeg has any of the named encoders. Without
+// an answer from FFmpeg it has none.
+func encoders(ctx context.Context, names ...string) bool {
+	c, err := Caps(ctx)
 	if err != nil {
-		return CPU
+		return false
 	}
+	for _, name := range names {
+		if c.HasEncoder(name) {
+			return true
+		}
+	}
+	return false
+}
 
-	output := string(out)
-
-	if strings.Contains(output, "h264_nvenc") ||
-		strings.Contains(output, "hevc_nvenc") {
+func DetectGPU(ctx context.Context) GPU {
+	if encoders(ctx, "h264_nvenc", "hevc_nvenc") {
 		return NVIDIA
 	}
 
-	if strings.Contains(output, "h264_qsv") ||
-		strings.Contains(output, "hevc_qsv") {
+	if encoders(ctx, "h264_qsv", "hevc_qsv") {
 		return INTEL
 	}
 
-	if strings.Contains(output, "h264_amf") ||
-		strings.Contains(output, "hevc_amf") {
+	if encoders(ctx, "h264_amf", "hevc_amf") {
 		return AMD
 	}
 
-	if strings.Contains(output, "h264_vaapi") ||
-		strings.Contains(output, "hevc_vaapi") {
-		vendorCmd := exec.Command("lspci", "|", "grep", "-i", "vga")
-		vendorOut, _ := vendorCmd.Output()
-		vendorStr := string(vendorOut)
-
-		if strings.Contains(vendorStr, "Intel") {
-			return INTEL
-		} else if strings.Contains(vendorStr, "AMD") ||
-			strings.Contains(vendorStr, "ATI") {
-			return AMD
+	if encoders(ctx, "h264_vaapi", "hevc_vaapi") {
+		if vendor, ok := vaapiVendor(ctx); ok {
+			return vendor
 		}
 	}
 
 	return CPU
 }
 
-func HasEncoder(encoderName string) bool {
-	cmd := exec.Command("ffmpeg", "-encoders")
-	out, err := cmd.Output()
+// VAAPIGPU is the backend a VAAPI encode runs on: the vendor lspci names,
+// or INTEL when it cannot tell.
+func VAAPIGPU(ctx context.Context) GPU {
+	if vendor, ok := vaapiVendor(ctx); ok {
+		return vendor
+	}
+	return INTEL
+}
+
+// vaapiVendor tells whose GPU VAAPI runs on from the VGA controllers lspci
+// lists. ok is false when lspci is missing or names neither Intel nor AMD.
+func vaapiVendor(ctx context.Context) (GPU, bool) {
+	out, err := exec.CommandContext(ctx, "lspci").Output()
 	if err != nil {
-		return false
+		return "", false
 	}
-	return strings.Contains(string(out), encoderName)
+
+	var vga []string
+	for _, line := range strings.Split(string(out), "\n") {
+		if strings.Contains(strings.ToLower(line), "vga") {
+			vga = append(vga, line)
+		}
+	}
+	controllers := strings.Join(vga, "\n")
+
+	switch {
+	case strings.Contains(controllers, "Intel"):
+		return INTEL, true
+	case strings.Contains(controllers, "AMD") || strings.Contains(controllers, "ATI"):
+		return AMD, true
+	}
+	return "", false
 }
 
-func SetForcedGPU(mode string) GPU {
+func HasEncoder(ctx context.Context, encoderName string) bool {
+	return encoders(ctx, encoderName)
+}
+
+func ResolveGPU(ctx context.Context, mode string) GPU {
 	switch mode {
 	case "nvidia":
-		if HasEncoder("h264_nvenc") || HasEncoder("hevc_nvenc") {
-			forcedGPU = NVIDIA
+		if HasEncoder(ctx, "h264_nvenc") || HasEncoder(ctx, "hevc_nvenc") {
 			return NVIDIA
 		}
-		forcedGPU = CPU
 		return CPU
 
 	case "intel", "qsv":
-		if HasEncoder("h264_qsv") || HasEncoder("hevc_qsv") {
-			forcedGPU = INTEL
+		if HasEncoder(ctx, "h264_qsv") || HasEncoder(ctx, "hevc_qsv") {
 			return INTEL
 		}
-		if HasEncoder("h264_vaapi") || HasEncoder("hevc_vaapi") {
-			forcedGPU = INTEL
+		if HasEncoder(ctx, "h264_vaapi") || HasEncoder(ctx, "hevc_vaapi") {
 			return INTEL
 		}
-		forcedGPU = CPU
 		return CPU
 
 	case "amd":
-		if HasEncoder("h264_amf") || HasEncoder("hevc_amf") {
-			forcedGPU = AMD
+		if HasEncoder(ctx, "h264_amf") || HasEncoder(ctx, "hevc_amf") {
 			return AMD
 		}
-		if HasEncoder("h264_vaapi") || HasEncoder("hevc_vaapi") {
-			forcedGPU = AMD
+		if HasEncoder(ctx, "h264_vaapi") || HasEncoder(ctx, "hevc_vaapi") {
 			return AMD
 		}
-		forcedGPU = CPU
 		return CPU
 
 	case "gpu", "igpu":
-		if HasEncoder("h264_nvenc") || HasEncoder("hevc_nvenc") {
-			forcedGPU = NVIDIA
+		if HasEncoder(ctx, "h264_nvenc") || HasEncoder(ctx, "hevc_nvenc") {
 			return NVIDIA
 		}
-		if HasEncoder("h264_qsv") || HasEncoder("hevc_qsv") {
-			forcedGPU = INTEL
+		if HasEncoder(ctx, "h264_qsv") || HasEncoder(ctx, "hevc_qsv") {
 			return INTEL
 		}
-		if HasEncoder("h264_amf") || HasEncoder("hevc_amf") {
-			forcedGPU = AMD
+		if HasEncoder(ctx, "h264_amf") || HasEncoder(ctx, "hevc_amf") {
 			return AMD
 		}
-		if HasEncoder("h264_vaapi") || HasEncoder("hevc_vaapi") {
-			forcedGPU = INTEL
+		if HasEncoder(ctx, "h264_vaapi") || HasEncoder(ctx, "hevc_vaapi") {
 			return INTEL
 		}
-		forcedGPU = CPU
 		return CPU
 
 	case "cpu":
-		forcedGPU = CPU
 		return CPU
 
 	default:
-		forcedGPU = ""
-		return DetectGPU()
+		return DetectGPU(ctx)
 	}
 }
 
-func ResetForcedGPU() {
-	forcedGPU = ""
-}
-
-func GetAvailableGPUs() []GPU {
+func GetAvailableGPUs(ctx context.Context) []GPU {
 	var gpus []GPU
 
-	cmd := exec.Command("ffmpeg", "-encoders")
-	out, err := cmd.Output()
-	if err != nil {
-		return []GPU{CPU}
-	}
-
-	output := string(out)
-
-	if strings.Contains(output, "h264_nvenc") ||
-		strings.Contains(output, "hevc_nvenc") {
+	if encoders(ctx, "h264_nvenc", "hevc_nvenc") {
 		gpus = append(gpus, NVIDIA)
 	}
 
-	if strings.Contains(output, "h264_qsv") ||
-		strings.Contains(output, "hevc_qsv") {
+	if encoders(ctx, "h264_qsv", "hevc_qsv") {
 		gpus = append(gpus, INTEL)
 	}
 
-	if strings.Contains(output, "h264_amf") ||
-		strings.Contains(output, "hevc_amf") {
+	if encoders(ctx, "h264_amf", "hevc_amf") {
 		gpus = append(gpus, AMD)
 	}
 
-	if strings.Contains(output, "h264_vaapi") ||
-		strings.Contains(output, "hevc_vaapi") {
+	if encoders(ctx, "h264_vaapi", "hevc_vaapi") {
 		vaapiAdded := false
 		for _, g := range gpus {
 			if g == INTEL || g == AMD {
@@ -184,3 +177,11 @@ func GetAvailableGPUs() []GPU {
 
 	return gpus
 }
+
+func HasFilter(ctx context.Context, filterName string) bool {
+	c, err := Caps(ctx)
+	if err != nil {
+		return false
+	}
+	return c.HasFilter(filterName)
+}
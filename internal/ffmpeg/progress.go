@@ -0,0 +1,53 @@
+package ffmpeg
+
+import (
+	"bufio"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Progress is one block of "-progress" output. Bitrate is in kbit/s and
+// OutTime in seconds; fields FFmpeg reports as N/A stay zero.
+type Progress struct {
+	Frame     int64   `json:"frame"`
+	FPS       float64 `json:"fps"`
+	Bitrate   float64 `json:"bitrate_kbps"`
+	TotalSize int64   `json:"total_size"`
+	OutTime   float64 `json:"out_time"`
+	Speed     float64 `json:"speed"`
+	End       bool    `json:"end,omitempty"`
+}
+
+// ReadProgress parses the key=value stream of "-progress pipe:1" and calls
+// fn at the end of every block, which FFmpeg marks with a progress= line.
+func ReadProgress(r io.Reader, fn func(p Progress)) {
+	var p Progress
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(strings.TrimSpace(scanner.Text()), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "frame":
+			p.Frame, _ = strconv.ParseInt(value, 10, 64)
+		case "fps":
+			p.FPS, _ = strconv.ParseFloat(value, 64)
+		case "bitrate":
+			p.Bitrate, _ = strconv.ParseFloat(strings.TrimSuffix(value, "kbits/s"), 64)
+		case "total_size":
+			p.TotalSize, _ = strconv.ParseInt(value, 10, 64)
+		case "out_time_us", "out_time_ms":
+			// Both are in microseconds, despite the name of the second.
+			if us, err := strconv.ParseFloat(value, 64); err == nil {
+				p.OutTime = us / 1_000_000
+			}
+		case "speed":
+			p.Speed, _ = strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(value, "x")), 64)
+		case "progress":
+			p.End = value == "end"
+			fn(p)
+		}
+	}
+}
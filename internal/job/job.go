@@ -0,0 +1,913 @@
+package job
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"kiourin-studio/video-resolution/internal/chunk"
+	"kiourin-studio/video-resolution/internal/encoder"
+	"kiourin-studio/video-resolution/internal/events"
+	"kiourin-studio/video-resolution/internal/ffmpeg"
+	"kiourin-studio/video-resolution/internal/logger"
+	"kiourin-studio/video-resolution/internal/output"
+	"kiourin-studio/video-resolution/internal/pipeline"
+	"kiourin-studio/video-resolution/internal/probe"
+	"kiourin-studio/video-resolution/internal/scaler"
+)
+
+type Options struct {
+	Mode          string                 `json:"mode"`
+	Profile       encoder.Profile        `json:"profile"`
+	GPUMode       string                 `json:"gpu_mode"`
+	Compress      bool                   `json:"compress,omitempty"`
+	UpscaleMethod string                 `json:"upscale_method,omitempty"`
+	SRModel       string                 `json:"sr_model,omitempty"`
+	Enhance       []pipeline.Enhancement `json:"enhance,omitempty"`
+	Align         int                    `json:"align,omitempty"`
+	NoSnap        bool                   `json:"no_snap,omitempty"`
+	Threads       int                    `json:"threads,omitempty"`
+	Chunks        int                    `json:"chunks,omitempty"`
+	MinSavings    float64                `json:"min_savings,omitempty"`
+	KeepOriginal  string                 `json:"keep_original,omitempty"`
+	Name          string                 `json:"name,omitempty"`
+	Overwrite     output.Policy          `json:"overwrite,omitempty"`
+	ProbeTimeout  time.Duration          `json:"probe_timeout,omitempty"`
+	DetectTimeout time.Duration          `json:"detect_timeout,omitempty"`
+	StallTimeout  time.Duration          `json:"stall_timeout,omitempty"`
+}
+
+// Defaults for timeouts left at zero in Options. A negative timeout
+// turns the limit off.
+const (
+	DefaultProbeTimeout  = 30 * time.Second
+	DefaultDetectTimeout = 15 * time.Second
+	DefaultStallTimeout  = 2 * time.Minute
+)
+
+// withTimeout bounds ctx by d, or by def when d is zero.
+func withTimeout(ctx context.Context, d, def time.Duration) (context.Context, context.CancelFunc) {
+	if d == 0 {
+		d = def
+	}
+	if d < 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, d)
+}
+
+// ProbeContext bounds ctx by the probe timeout, for ffprobe calls.
+func (o Options) ProbeContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, o.ProbeTimeout, DefaultProbeTimeout)
+}
+
+// DetectContext bounds ctx by the detection timeout, for asking FFmpeg
+// which encoders and filters it has.
+func (o Options) DetectContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return withTimeout(ctx, o.DetectTimeout, DefaultDetectTimeout)
+}
+
+func (o Options) stallTimeout() time.Duration {
+	switch {
+	case o.StallTimeout == 0:
+		return DefaultStallTimeout
+	case o.StallTimeout < 0:
+		return 0
+	}
+	return o.StallTimeout
+}
+
+type Job struct {
+	DryRun   bool
+	Input    string
+	Output   string
+	OutDir   string
+	Log      *logger.Logger
+	Progress func(u Update)
+	Planned  func(output string)
+	Confirm  func(path string) bool
+	Emit     func(event string, data any)
+}
+
+// Code says which step a job failed in.
+type Code string
+
+const (
+	MissingDependency  Code = "missing_dependency"
+	InputError         Code = "input_error"
+	ProbeError         Code = "probe_error"
+	OutputError        Code = "output_error"
+	EncoderUnavailable Code = "encoder_unavailable"
+	EncodeFailed       Code = "encode_failed"
+	Cancelled          Code = "cancelled"
+	VerifyFailed       Code = "verification_failed"
+)
+
+type Result struct {
+	Input    string
+	Output   string
+	Source   scaler.Resolution
+	Target   scaler.Resolution
+	Codec    string
+	Elapsed  time.Duration
+	Err      error
+	Code     Code
+	InSize   int64
+	OutSize  int64
+	Mode     string
+	Compress bool
+	Skipped  bool
+	NotWorth bool
+	Savings  float64
+	Plan     *Plan
+	Attempts []Attempt
+}
+
+// Attempt is an encoder that failed before the one in Result.Codec took
+// over, or before the job gave up.
+type Attempt struct {
+	Codec   string         `json:"codec"`
+	Failure ffmpeg.Failure `json:"failure"`
+	Reason  string         `json:"reason"`
+}
+
+// Plan is what a job would do, filled in instead of encoding when the
+// job is a dry run. Commands are ffmpeg's arguments, one entry per run.
+type Plan struct {
+	Input    string            `json:"input"`
+	Output   string            `json:"output"`
+	Exists   bool              `json:"exists,omitempty"`
+	Skipped  bool              `json:"skipped,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	GPU      string            `json:"gpu,omitempty"`
+	Codec    string            `json:"codec,omitempty"`
+	Params   []string          `json:"params,omitempty"`
+	Pipeline string            `json:"pipeline,omitempty"`
+	Filters  string            `json:"filters,omitempty"`
+	Source   scaler.Resolution `json:"source"`
+	Target   scaler.Resolution `json:"target"`
+	Duration float64           `json:"duration,omitempty"`
+	Commands [][]string        `json:"commands,omitempty"`
+}
+
+var extensions = []string{".mp4", ".mov", ".avi", ".mkv", ".webm", ".flv", ".wmv"}
+
+func IsVideo(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range extensions {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+func Run(ctx context.Context, j Job, gpu ffmpeg.GPU, opts Options) Result {
+	started := time.Now()
+	result := run(ctx, j, gpu, opts)
+	result.Elapsed = time.Since(started)
+	if result.Err != nil {
+		j.emit("error", events.Message{Message: result.Err.Error(), Code: string(result.Code)})
+	}
+	if !j.DryRun {
+		j.emit("result", result.event())
+	}
+	return result
+}
+
+func (j Job) emit(event string, data any) {
+	if j.Emit != nil {
+		j.Emit(event, data)
+	}
+}
+
+type probeEvent struct {
+	Width     int     `json:"width"`
+	Height    int     `json:"height"`
+	Codec     string  `json:"codec,omitempty"`
+	FrameRate float64 `json:"fps,omitempty"`
+	Duration  float64 `json:"duration"`
+	Frames    int64   `json:"frames,omitempty"`
+	Size      int64   `json:"size"`
+}
+
+// Update is a progress report from a running encode. Percent and ETA
+// (in seconds) stay zero while they cannot be told.
+type Update struct {
+	Percent float64 `json:"percent,omitempty"`
+	ETA     float64 `json:"eta,omitempty"`
+	ffmpeg.Progress
+}
+
+type fallbackEvent struct {
+	From    string         `json:"from"`
+	To      string         `json:"to"`
+	Reason  string         `json:"reason"`
+	Failure ffmpeg.Failure `json:"failure,omitempty"`
+}
+
+type resultEvent struct {
+	Output   string            `json:"output,omitempty"`
+	Codec    string            `json:"codec,omitempty"`
+	Source   scaler.Resolution `json:"source"`
+	Target   scaler.Resolution `json:"target"`
+	InSize   int64             `json:"in_size"`
+	OutSize  int64             `json:"out_size"`
+	Elapsed  float64           `json:"elapsed"`
+	Skipped  bool              `json:"skipped,omitempty"`
+	NotWorth bool              `json:"not_worth_it,omitempty"`
+	Savings  float64           `json:"savings,omitempty"`
+	Error    string            `json:"error,omitempty"`
+	Code     Code              `json:"code,omitempty"`
+	Attempts []Attempt         `json:"attempts,omitempty"`
+}
+
+func (r Result) event() resultEvent {
+	e := resultEvent{
+		Output:   r.Output,
+		Codec:    r.Codec,
+		Source:   r.Source,
+		Target:   r.Target,
+		InSize:   r.InSize,
+		OutSize:  r.OutSize,
+		Elapsed:  r.Elapsed.Seconds(),
+		Skipped:  r.Skipped,
+		NotWorth: r.NotWorth,
+		Savings:  r.Savings,
+		Code:     r.Code,
+		Attempts: r.Attempts,
+	}
+	if r.Err != nil {
+		e.Error = r.Err.Error()
+	}
+	return e
+}
+
+func run(ctx context.Context, j Job, detectedGPU ffmpeg.GPU, opts Options) Result {
+	input := j.Input
+	log := j.Log.WithJob(input)
+	if j.Emit != nil {
+		log = log.WithHook(func(level slog.Level, tag, msg string) {
+			if level == slog.LevelWarn {
+				j.emit("warning", events.Message{Message: msg})
+			}
+		})
+	}
+	mode := opts.Mode
+	profile := opts.Profile
+	compress := opts.Compress
+
+	result := Result{Input: input, Mode: mode, Compress: compress}
+	if ctx.Err() != nil {
+		result.Err = ctx.Err()
+		result.Code = Cancelled
+		return result
+	}
+
+	info, err := os.Stat(input)
+	if os.IsNotExist(err) {
+		log.Error("Error", fmt.Sprintf("File not found: %s", input))
+		result.Err = fmt.Errorf("file not found")
+		result.Code = InputError
+		return result
+	}
+	if err == nil {
+		result.InSize = info.Size()
+	}
+
+	log.Info("Scan", "Reading video info...")
+	probeCtx, cancelProbe := opts.ProbeContext(ctx)
+	defer cancelProbe()
+	media, err := probe.Probe(probeCtx, input)
+	var video probe.Stream
+	if err == nil {
+		video, err = media.Video()
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			result.Err = ctx.Err()
+			result.Code = Cancelled
+			return result
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("ffprobe did not answer in time")
+		}
+		log.Error("Error", fmt.Sprintf("Cannot read video: %v", err))
+		result.Err = fmt.Errorf("cannot read video: %w", err)
+		result.Code = ProbeError
+		return result
+	}
+	dur := media.Duration()
+
+	log.Info("Scan", fmt.Sprintf("Resolution: %dx%d", video.Width, video.Height))
+	if video.Codec != "" {
+		log.Info("Scan", fmt.Sprintf("Codec: %s, %.3g fps", video.Codec, video.FrameRate))
+	}
+	if dur > 0 {
+		log.Info("Scan", fmt.Sprintf("Duration: %.0f sec", dur))
+	}
+	var frames int64
+	if dur <= 0 {
+		frames = video.Frames
+		if frames > 0 {
+			log.Info("Scan", fmt.Sprintf("Frames: %d", frames))
+		}
+	}
+	j.emit("probe", probeEvent{
+		Width:     video.Width,
+		Height:    video.Height,
+		Codec:     video.Codec,
+		FrameRate: video.FrameRate,
+		Duration:  dur,
+		Frames:    frames,
+		Size:      result.InSize,
+	})
+
+	source := scaler.Resolution{W: video.Width, H: video.Height}
+	target := Target(source, opts)
+	if mode == "none" {
+		log.Info("Plan", "Mode: No scaling (compress only)")
+	}
+
+	detectCtx, cancelDetect := opts.DetectContext(ctx)
+	defer cancelDetect()
+	enc := encoder.For(detectCtx, detectedGPU, profile)
+	target, enc, detectedGPU = fitEncoder(detectCtx, log, target, enc, detectedGPU, profile, opts.GPUMode, opts.Align)
+	result.Source = source
+	result.Target = target
+
+	gpuName := string(detectedGPU)
+	if detectedGPU == ffmpeg.CPU {
+		gpuName = "CPU (software)"
+	}
+	log.Info("GPU ", strings.ToUpper(gpuName)+" detected")
+
+	if mode != "none" {
+		log.Info("Plan", "Mode: "+map[string]string{"up": "Upscale", "down": "Downscale"}[mode])
+	}
+	log.Info("Plan", "Profile: "+string(profile))
+	if compress {
+		log.Info("Plan", "Compression: ON")
+	}
+	if len(opts.Enhance) > 0 {
+		names := make([]string, len(opts.Enhance))
+		for i, e := range opts.Enhance {
+			names[i] = string(e)
+		}
+		log.Info("Plan", "Enhance: "+strings.Join(names, ", "))
+	}
+	log.Info("Plan", fmt.Sprintf("Target: %dx%d", target.W, target.H))
+
+	if compress {
+		enc = encoder.ApplyCompression(enc, detectedGPU, profile)
+	}
+	enc = withThreads(enc, opts.Threads)
+
+	out, skip, err := resolveOutput(log, j, opts, input, outputPath(j, opts, source, target, enc.Codec))
+	if err != nil {
+		result.Err = err
+		result.Code = OutputError
+		return result
+	}
+	// Under the rename policy out is a new name, so this is checked after
+	// the policy had its say.
+	exists := fileExists(out)
+	result.Output = out
+	if skip {
+		result.Skipped = true
+		return result
+	}
+	tmp := output.Temp(out)
+
+	filters := pipeline.PreScale(opts.Enhance, profile)
+	if mode == "up" && opts.UpscaleMethod == "sr" {
+		filters = append(filters, superResolution(detectCtx, log, opts.SRModel, source, target)...)
+	}
+	if target != source {
+		filters = append(filters, pipeline.Scale(target.W, target.H))
+	}
+	filters = append(filters, pipeline.PostScale(opts.Enhance, profile)...)
+
+	job := pipeline.Job{
+		Input:   input,
+		Output:  tmp,
+		Encoder: enc,
+		Filters: filters,
+	}
+	ffmpegLog := log.Writer("FFmpeg", slog.LevelWarn)
+	if log.Enabled(logger.Trace) {
+		job.LogLevel = "info"
+		ffmpegLog = log.Writer("FFmpeg", logger.Trace)
+	}
+
+	accelName := "software"
+	if job.Accel() != pipeline.Software {
+		accelName = string(job.Accel())
+	}
+	log.Debug("Debug", fmt.Sprintf("Codec: %s", enc.Codec))
+	log.Debug("Debug", fmt.Sprintf("Params: %v", enc.Params))
+	log.Debug("Debug", fmt.Sprintf("Pipeline: %s", accelName))
+
+	// Every segment opens an encoder session of its own. Hardware encoders
+	// would go past the session limit the worker pools keep to, so only
+	// the CPU encoder is split.
+	chunks := opts.Chunks
+	if chunks > 1 && detectedGPU != ffmpeg.CPU {
+		log.Warn("Warning", fmt.Sprintf("-chunks only splits CPU encodes, encoding on %s in one piece", strings.ToUpper(string(detectedGPU))))
+		chunks = 1
+	}
+
+	var segments []chunk.Segment
+	commands := [][]string{pipeline.Args(job)}
+	if chunks > 1 {
+		segments = planChunks(ctx, log, input, media.Format.StartTime, dur, chunks)
+		commands = chunk.Commands(job, segments)
+	}
+	plan := &Plan{
+		Input:    input,
+		Output:   out,
+		Exists:   exists,
+		GPU:      string(detectedGPU),
+		Codec:    enc.Codec,
+		Params:   enc.Params,
+		Pipeline: accelName,
+		Filters:  pipeline.Chain(job.Accel(), filters),
+		Source:   source,
+		Target:   target,
+		Duration: dur,
+		Commands: commands,
+	}
+	j.emit("plan", plan)
+
+	if j.DryRun {
+		result.Codec = enc.Codec
+		result.Plan = plan
+		return result
+	}
+
+	if dir := filepath.Dir(out); dir != "." {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			log.Error("Error", fmt.Sprintf("Cannot create output directory: %v", err))
+			result.Err = err
+			result.Code = OutputError
+			return result
+		}
+	}
+
+	if j.Planned != nil {
+		j.Planned(out)
+	}
+
+	log.Info("Run ", "Encoding started...")
+	defer os.Remove(tmp)
+
+	track := tracker{duration: dur, frames: frames, started: time.Now()}
+	reportProgress := func(fp ffmpeg.Progress) {
+		if ctx.Err() != nil {
+			// FFmpeg keeps reporting while it winds down; the stop message
+			// has already ended the progress line.
+			return
+		}
+		f, known := track.fraction(fp)
+		u := Update{Progress: fp}
+		if known {
+			u.Percent = f * 100
+			if eta, ok := track.eta(f); ok {
+				u.ETA = eta.Seconds()
+			}
+		}
+		j.emit("progress", u)
+
+		if j.Progress != nil {
+			j.Progress(u)
+			return
+		}
+		log.Progress(track.line(fp, log != nil && log.Shared))
+	}
+
+	// Hardware encoders often fail only once the encode is under way: no
+	// free session, a size the chip cannot do, a driver error. Failures
+	// like that start over with the next encoder in line.
+	var fallbacks []encoder.Fallback
+	if opts.GPUMode == "" || opts.GPUMode == "auto" {
+		fallbackCtx, cancel := opts.DetectContext(ctx)
+		fallbacks = encoder.Fallbacks(fallbackCtx, enc.Codec, profile)
+		cancel()
+	} else if detectedGPU != ffmpeg.CPU {
+		fallbacks = []encoder.Fallback{{GPU: ffmpeg.CPU, Config: encoder.For(ctx, ffmpeg.CPU, profile)}}
+	}
+
+	for {
+		result.Codec = enc.Codec
+		track.started = time.Now()
+		stderr, err := encode(ctx, job, segments, chunks, opts.stallTimeout(), log, ffmpegLog, reportProgress)
+		if j.Progress == nil {
+			log.EndProgress()
+		}
+		if err == nil || ctx.Err() != nil {
+			return encoded(ctx, log, j, result, err, tmp, dur, opts)
+		}
+
+		failure, reason := ffmpeg.Classify(err, stderr)
+		result.Attempts = append(result.Attempts, Attempt{Codec: enc.Codec, Failure: failure, Reason: reason})
+		log.Error("Error", fmt.Sprintf("%s failed: %s", enc.Codec, reason))
+
+		next, ok := nextEncoder(&fallbacks, target, opts.Align)
+		if !failure.Hardware() || !ok {
+			result = encoded(ctx, log, j, result, err, tmp, dur, opts)
+			if failure.Hardware() || failure == ffmpeg.NotRun {
+				result.Code = EncoderUnavailable
+			}
+			return result
+		}
+
+		log.Warn("Warning", fmt.Sprintf("Retrying with %s", next.Config.Codec))
+		j.emit("fallback", fallbackEvent{From: enc.Codec, To: next.Config.Codec, Reason: reason, Failure: failure})
+
+		enc = next.Config
+		if compress {
+			enc = encoder.ApplyCompression(enc, next.GPU, profile)
+		}
+		enc = withThreads(enc, opts.Threads)
+		job.Encoder = enc
+	}
+}
+
+// nextEncoder takes the first fallback that can encode target.
+func nextEncoder(fallbacks *[]encoder.Fallback, target scaler.Resolution, align int) (encoder.Fallback, bool) {
+	for len(*fallbacks) > 0 {
+		next := (*fallbacks)[0]
+		*fallbacks = (*fallbacks)[1:]
+		if encoder.WithAlign(encoder.LimitsFor(next.Config.Codec), align).Fits(target) {
+			return next, true
+		}
+	}
+	return encoder.Fallback{}, false
+}
+
+// encode runs one attempt at the whole encode, in chunks when there are
+// segments, and returns the end of FFmpeg's stderr for a failure.
+func encode(ctx context.Context, job pipeline.Job, segments []chunk.Segment, workers int, stall time.Duration, log *logger.Logger, ffmpegLog io.Writer, progress func(ffmpeg.Progress)) (string, error) {
+	if workers > 1 {
+		// Segment errors carry their own stderr.
+		return "", chunk.Encode(ctx, job, segments, workers, stall, log, progress)
+	}
+
+	var tail ffmpeg.Tail
+	err := ffmpeg.RunProgress(ctx, pipeline.Args(job), io.MultiWriter(ffmpegLog, &tail), stall, progress)
+	return tail.String(), err
+}
+
+// encoded settles the outcome of an encode. A cancelled encode counts as
+// cancelled even when FFmpeg closed its output cleanly after the "q".
+func encoded(ctx context.Context, log *logger.Logger, j Job, result Result, err error, tmp string, dur float64, opts Options) Result {
+	if ctx.Err() != nil {
+		log.Warn("Stop", "Encoding cancelled, partial output removed")
+		result.Err = ctx.Err()
+		result.Code = Cancelled
+		return result
+	}
+	if err != nil {
+		log.Error("Error", fmt.Sprintf("Encoding failed: %v", err))
+		result.Err = fmt.Errorf("encoding failed: %w", err)
+		result.Code = EncodeFailed
+		return result
+	}
+	return commit(ctx, log, j, result, tmp, dur, opts)
+}
+
+// commit moves a finished encode from tmp to its final name once it looks
+// complete. Until then an older file with that name is left untouched.
+func commit(ctx context.Context, log *logger.Logger, j Job, result Result, tmp string, dur float64, opts Options) Result {
+	probeCtx, cancel := opts.ProbeContext(ctx)
+	defer cancel()
+	if err := verify(probeCtx, tmp, dur); err != nil {
+		log.Error("Error", fmt.Sprintf("Output check failed: %v", err))
+		result.Err = fmt.Errorf("output check failed: %w", err)
+		result.Code = VerifyFailed
+		return result
+	}
+	if opts.MinSavings > 0 && result.Source == result.Target && result.InSize > 0 {
+		info, err := os.Stat(tmp)
+		if err == nil {
+			result.Savings = float64(result.InSize-info.Size()) / float64(result.InSize) * 100
+			if result.Savings < opts.MinSavings {
+				return keepOriginal(log, j, result, tmp, opts)
+			}
+		}
+	}
+	if err := os.Rename(tmp, result.Output); err != nil {
+		log.Error("Error", fmt.Sprintf("Cannot move output into place: %v", err))
+		result.Err = err
+		result.Code = OutputError
+		return result
+	}
+	return finish(log, result)
+}
+
+// resolveOutput checks that out does not replace input and applies the
+// overwrite policy to it. It returns the path to write, or skip when an
+// existing file must be left alone.
+func resolveOutput(log *logger.Logger, j Job, opts Options, input, out string) (string, bool, error) {
+	if output.Same(out, input) {
+		log.Error("Error", fmt.Sprintf("Output would replace the input: %s", out))
+		return out, false, fmt.Errorf("output would replace the input")
+	}
+
+	policy := opts.Overwrite
+	if policy == "" {
+		policy = output.Always
+	}
+	confirm := j.Confirm
+	if j.DryRun {
+		confirm = func(string) bool { return true }
+	}
+	exists := fileExists(out)
+	resolved, err := output.Resolve(out, policy, confirm)
+	if err == output.ErrExists {
+		log.Warn("Warning", fmt.Sprintf("Output file already exists: %s", out))
+		log.Info("Info", "Skipping (overwrite: "+string(policy)+")")
+		return out, true, nil
+	}
+	if resolved != out {
+		log.Info("Info", fmt.Sprintf("Output file already exists, writing %s", resolved))
+	} else if exists && policy == output.Always {
+		log.Warn("Warning", fmt.Sprintf("Output file already exists: %s", out))
+		log.Info("Info", "It will be overwritten automatically")
+	}
+	return resolved, false, nil
+}
+
+// keepOriginal drops an encode that did not save enough space. With
+// KeepOriginal set to copy or link, the original takes its place so the
+// output folder stays complete.
+func keepOriginal(log *logger.Logger, j Job, result Result, tmp string, opts Options) Result {
+	log.Info("Info", fmt.Sprintf("Not worth it: saves %.1f%% (minimum %g%%), keeping the original",
+		result.Savings, opts.MinSavings))
+	result.NotWorth = true
+	os.Remove(tmp)
+
+	if opts.KeepOriginal == "" {
+		result.Output = ""
+		return result
+	}
+
+	// The original keeps its own container, so it keeps its extension too.
+	// That is a different file, so it goes through the policy again.
+	if path := strings.TrimSuffix(result.Output, filepath.Ext(result.Output)) + filepath.Ext(result.Input); path != result.Output {
+		path, skip, err := resolveOutput(log, j, opts, result.Input, path)
+		if err != nil {
+			result.Err = err
+			result.Code = OutputError
+			return result
+		}
+		result.Output = path
+		if skip {
+			result.Skipped = true
+			return result
+		}
+	}
+
+	err := placeOriginal(result.Input, tmp, opts.KeepOriginal)
+	if err == nil {
+		err = os.Rename(tmp, result.Output)
+	}
+	if err != nil {
+		log.Error("Error", fmt.Sprintf("Cannot %s the original: %v", opts.KeepOriginal, err))
+		result.Err = err
+		result.Code = OutputError
+		return result
+	}
+	result.OutSize = result.InSize
+	log.Info("Done", fmt.Sprintf("Original %s to %s", map[string]string{"copy": "copied", "link": "linked"}[opts.KeepOriginal], result.Output))
+	return result
+}
+
+// placeOriginal puts a copy or hard link of input at path. Hard links
+// cannot cross file systems, so those fall back to a copy.
+func placeOriginal(input, path, mode string) error {
+	if mode == "link" && os.Link(input, path) == nil {
+		return nil
+	}
+
+	src, err := os.Open(input)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+func verify(ctx context.Context, path string, dur float64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("output is empty")
+	}
+	media, err := probe.Probe(ctx, path)
+	if err == nil {
+		_, err = media.Video()
+	}
+	if err != nil {
+		return fmt.Errorf("output is not readable: %w", err)
+	}
+	if dur > 0 {
+		if got := media.Duration(); got > 0 && dur-got > max(1, dur*0.02) {
+			return fmt.Errorf("output is %.1fs long, expected %.1fs", got, dur)
+		}
+	}
+	return nil
+}
+
+func finish(log *logger.Logger, result Result) Result {
+	if info, err := os.Stat(result.Output); err == nil {
+		result.OutSize = info.Size()
+	}
+
+	log.Info("Done", fmt.Sprintf("Saved as %s", result.Output))
+
+	log.Info("Info", fmt.Sprintf("Operation: %s", result.Operation()))
+	log.Info("Info", fmt.Sprintf("Original: %dx%d → Target: %dx%d",
+		result.Source.W, result.Source.H, result.Target.W, result.Target.H))
+	log.Info("Info", fmt.Sprintf("Encoder: %s", result.Codec))
+	for _, a := range result.Attempts {
+		log.Info("Info", fmt.Sprintf("Fell back from %s (%s)", a.Codec, a.Reason))
+	}
+	if result.Compress {
+		log.Info("Info", "Compression: Applied")
+	}
+
+	return result
+}
+
+// planChunks splits the input at keyframes into segments for several
+// workers. Keyframe times are timestamps, while -ss seeks from the file's
+// start time, so they are moved by start first. Without a duration or
+// keyframes there is nothing to split, so it falls back to a single
+// segment. Finding keyframes reads the whole file, so it is bounded by
+// ctx only, not by the probe timeout.
+func planChunks(ctx context.Context, log *logger.Logger, input string, start, dur float64, workers int) []chunk.Segment {
+	var keyframes []float64
+	if dur > 0 {
+		log.Info("Chunk", "Finding keyframes...")
+		times, err := probe.Keyframes(ctx, input)
+		if err != nil {
+			log.Warn("Warning", fmt.Sprintf("Cannot read keyframes: %v", err))
+		}
+		for _, t := range times {
+			keyframes = append(keyframes, t-start)
+		}
+	}
+
+	segments := chunk.Plan(keyframes, dur, workers)
+	log.Info("Chunk", fmt.Sprintf("%d segment(s) on %d worker(s)", len(segments), workers))
+	return segments
+}
+
+func withThreads(enc encoder.Config, threads int) encoder.Config {
+	if threads <= 0 || enc.Codec != "libx264" {
+		return enc
+	}
+	params := append([]string{}, enc.Params...)
+	return encoder.Config{Codec: enc.Codec, Params: append(params, "-threads", strconv.Itoa(threads))}
+}
+
+func (r Result) Operation() string {
+	if r.Mode == "none" || r.Mode == "" {
+		return "Compressed"
+	}
+	operation := map[string]string{"up": "Upscaled", "down": "Downscaled"}[r.Mode]
+	if r.Compress {
+		operation += " and compressed"
+	}
+	return operation
+}
+
+func outputPath(j Job, opts Options, source, target scaler.Resolution, codec string) string {
+	if j.Output != "" {
+		return j.Output
+	}
+
+	dir := filepath.Dir(j.Input)
+	if j.OutDir != "" {
+		dir = j.OutDir
+	}
+
+	if opts.Name != "" {
+		return filepath.Join(dir, output.Format(opts.Name, output.Fields{
+			Input:   j.Input,
+			Width:   target.W,
+			Height:  target.H,
+			Codec:   codec,
+			Profile: string(opts.Profile),
+			Mode:    opts.Mode,
+		}))
+	}
+
+	suffixes := []string{}
+
+	if target != source {
+		suffixes = append(suffixes, fmt.Sprintf("%dx%d", target.W, target.H))
+	}
+	if opts.Compress {
+		suffixes = append(suffixes, "compressed")
+	}
+
+	name := output.Base(j.Input)
+	if len(suffixes) > 0 {
+		name += "-" + strings.Join(suffixes, "-")
+	}
+	return filepath.Join(dir, name+".mp4")
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func superResolution(ctx context.Context, log *logger.Logger, modelPath string, source, target scaler.Resolution) []pipeline.Filter {
+	if !ffmpeg.HasFilter(ctx, "sr") {
+		log.Warn("Warning", "FFmpeg has no sr filter, using lanczos upscaling")
+		return nil
+	}
+
+	models, err := scaler.LoadModels(modelPath)
+	if err != nil {
+		log.Warn("Warning", fmt.Sprintf("Cannot load SR model: %v, using lanczos upscaling", err))
+		return nil
+	}
+
+	factor := scaler.Factor(source, target)
+	model, n, ok := scaler.ChooseModel(models, factor)
+	if !ok {
+		return nil
+	}
+
+	log.Info("Plan", fmt.Sprintf("Super-resolution: %s x%d (%s)", model.Kind, n, model.Path))
+	if float64(n) != factor {
+		log.Info("Plan", fmt.Sprintf("Remaining %.2fx handled by lanczos", factor/float64(n)))
+	}
+
+	return []pipeline.Filter{pipeline.SuperResolution(model.Path, model.Kind == scaler.SRCNN, n)}
+}
+
+// Target is the size a job scales source to, before it is fitted to what
+// the encoder can do.
+func Target(source scaler.Resolution, opts Options) scaler.Resolution {
+	target := source
+	if opts.Mode != "none" {
+		target = scaler.Auto(source, opts.Mode)
+		if !opts.NoSnap {
+			target = scaler.Snap(source, target, opts.Mode)
+		}
+	}
+	if opts.Align > 0 {
+		target = scaler.Align(target, opts.Align)
+	}
+	return target
+}
+
+func fitEncoder(ctx context.Context, log *logger.Logger, target scaler.Resolution, enc encoder.Config, gpu ffmpeg.GPU, profile encoder.Profile, gpuMode string, align int) (scaler.Resolution, encoder.Config, ffmpeg.GPU) {
+	limits := encoder.WithAlign(encoder.LimitsFor(enc.Codec), align)
+	if limits.Fits(target) {
+		return target, enc, gpu
+	}
+
+	log.Warn("Warning", fmt.Sprintf("%dx%d is not supported by %s (%dx%d to %dx%d, multiple of %d)",
+		target.W, target.H, enc.Codec, limits.MinW, limits.MinH, limits.MaxW, limits.MaxH, max(limits.Align, 2)))
+
+	if gpuMode == "auto" {
+		for _, candidate := range ffmpeg.GetAvailableGPUs(ctx) {
+			if candidate == gpu {
+				continue
+			}
+			alt := encoder.For(ctx, candidate, profile)
+			if encoder.WithAlign(encoder.LimitsFor(alt.Codec), align).Fits(target) {
+				log.Warn("Warning", fmt.Sprintf("Switching to %s for this resolution", alt.Codec))
+				return target, alt, candidate
+			}
+		}
+	}
+
+	fitted := scaler.Fit(target, limits)
+	log.Warn("Warning", fmt.Sprintf("Clamping target to %dx%d", fitted.W, fitted.H))
+	return fitted, enc, gpu
+}
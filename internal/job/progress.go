@@ -0,0 +1,104 @@
+package job
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"kiourin-studio/video-resolution/internal/ffmpeg"
+)
+
+const barWidth = 24
+
+// tracker turns FFmpeg's progress reports into a fraction done, an ETA and
+// a status line. It measures against the duration, or the frame count
+// when the duration is unknown.
+type tracker struct {
+	duration float64
+	frames   int64
+	started  time.Time
+}
+
+func (t tracker) fraction(p ffmpeg.Progress) (float64, bool) {
+	var f float64
+	switch {
+	case t.duration > 0:
+		f = p.OutTime / t.duration
+	case t.frames > 0:
+		f = float64(p.Frame) / float64(t.frames)
+	default:
+		return 0, false
+	}
+	if p.End {
+		f = 1
+	}
+	return min(max(f, 0), 1), true
+}
+
+// eta extrapolates the time spent so far. It needs a little progress
+// before it means anything.
+func (t tracker) eta(f float64) (time.Duration, bool) {
+	if f < 0.01 || f >= 1 {
+		return 0, false
+	}
+	elapsed := time.Since(t.started)
+	return time.Duration(float64(elapsed) * (1 - f) / f), true
+}
+
+// line renders p for the terminal. compact leaves out the bar, for the
+// status line shared by concurrent jobs.
+func (t tracker) line(p ffmpeg.Progress, compact bool) string {
+	var parts []string
+
+	f, known := t.fraction(p)
+	if known {
+		if !compact {
+			filled := int(f * barWidth)
+			parts = append(parts, "["+strings.Repeat("#", filled)+strings.Repeat("-", barWidth-filled)+"]")
+		}
+		parts = append(parts, fmt.Sprintf("%.1f%%", f*100))
+		if eta, ok := t.eta(f); ok {
+			parts = append(parts, "ETA "+eta.Round(time.Second).String())
+		}
+	} else {
+		parts = append(parts, fmt.Sprintf("frame %d", p.Frame))
+	}
+
+	if compact {
+		if p.Speed > 0 {
+			parts = append(parts, fmt.Sprintf("%.2fx", p.Speed))
+		}
+		return strings.Join(parts, " ")
+	}
+
+	if p.FPS > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f fps", p.FPS))
+	}
+	if p.Speed > 0 {
+		parts = append(parts, fmt.Sprintf("%.2fx", p.Speed))
+	}
+	if p.Bitrate > 0 {
+		parts = append(parts, fmt.Sprintf("%.0f kbit/s", p.Bitrate))
+	}
+	if p.TotalSize > 0 {
+		if known && f > 0.01 {
+			parts = append(parts, "~"+formatBytes(int64(float64(p.TotalSize)/f)))
+		} else {
+			parts = append(parts, formatBytes(p.TotalSize))
+		}
+	}
+	return strings.Join(parts, "  ")
+}
+
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
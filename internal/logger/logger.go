@@ -1,23 +1,380 @@
 package logger
 
-import "fmt"
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
 
-func Info(tag, msg string) {
-	fmt.Printf("[%s] %s\n", tag, msg)
+// Trace is below Debug and also shows FFmpeg's own log.
+const Trace = slog.LevelDebug - 4
+
+var (
+	mu       sync.Mutex
+	out      io.Writer = os.Stdout
+	color              = useColor(os.Stdout)
+	level              = new(slog.LevelVar)
+	handler  slog.Handler
+	file     slog.Handler
+	progress = map[string]string{}
+	shown    int
+	inline   int
+)
+
+// SetOutput sends log lines and progress to w instead of stdout, leaving
+// stdout free for machine-readable output.
+func SetOutput(w io.Writer) {
+	mu.Lock()
+	defer mu.Unlock()
+	out = w
+	color = useColor(w)
 }
 
-func Inline(msg string) {
-	fmt.Printf("\r%s", msg)
+// SetLevel hides console messages below l. The log file always gets
+// everything.
+func SetLevel(l slog.Level) {
+	level.Set(l)
+}
+
+func Level() slog.Level {
+	return level.Level()
+}
+
+// SetHandler replaces the console output with h, for programs that use
+// vr's packages and have their own logging. nil restores the console.
+func SetHandler(h slog.Handler) {
+	mu.Lock()
+	defer mu.Unlock()
+	handler = h
+}
+
+// SetLogFile writes every message, at every level, to path as well.
+func SetLogFile(path string) (close func() error, err error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, err
+	}
+	mu.Lock()
+	file = slog.NewTextHandler(f, &slog.HandlerOptions{Level: slog.LevelDebug})
+	mu.Unlock()
+
+	return func() error {
+		mu.Lock()
+		file = nil
+		mu.Unlock()
+		return f.Close()
+	}, nil
+}
+
+func useColor(w io.Writer) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+func Debug(tag, msg string) {
+	write(slog.LevelDebug, nil, tag, msg, false)
+}
+
+func Info(tag, msg string) {
+	write(slog.LevelInfo, nil, tag, msg, false)
 }
 
 func Warn(tag, msg string) {
-	fmt.Printf("[%s] ⚠ %s\n", tag, msg)
+	write(slog.LevelWarn, nil, tag, msg, false)
 }
 
 func Error(tag, msg string) {
-	fmt.Printf("[%s] ✗ %s\n", tag, msg)
+	write(slog.LevelError, nil, tag, msg, false)
 }
 
 func Success(tag, msg string) {
-	fmt.Printf("[%s] ✓ %s\n", tag, msg)
+	write(slog.LevelInfo, nil, tag, msg, true)
+}
+
+func Inline(msg string) {
+	mu.Lock()
+	defer mu.Unlock()
+	if handler != nil || level.Level() > slog.LevelInfo {
+		return
+	}
+	// Pad over what is left of a longer previous line.
+	n := len([]rune(msg))
+	fmt.Fprintf(out, "\r%s%s", msg, strings.Repeat(" ", max(inline-n, 0)))
+	inline = n
+}
+
+// write hands a message to the console (or the handler set with
+// SetHandler) and to the log file, or only to the Logger's own Handler
+// when it has one. The tag and the job it belongs to go along as
+// attributes.
+func write(lvl slog.Level, l *Logger, tag, msg string, success bool) {
+	var handlers []slog.Handler
+	if l != nil && l.Handler != nil {
+		handlers = []slog.Handler{l.Handler}
+	} else {
+		mu.Lock()
+		handlers = []slog.Handler{console{}}
+		if handler != nil {
+			handlers[0] = handler
+		}
+		if file != nil {
+			handlers = append(handlers, file)
+		}
+		mu.Unlock()
+	}
+
+	r := slog.NewRecord(time.Now(), lvl, msg, 0)
+	r.AddAttrs(slog.String("tag", strings.TrimSpace(tag)))
+	if l != nil && l.Prefix != "" {
+		r.AddAttrs(slog.String("prefix", l.Prefix))
+	}
+	if l != nil && l.Job != "" {
+		r.AddAttrs(slog.String("job", l.Job))
+	}
+	if success {
+		r.AddAttrs(slog.Bool("success", true))
+	}
+
+	ctx := context.Background()
+	for _, h := range handlers {
+		if h.Enabled(ctx, lvl) {
+			h.Handle(ctx, r)
+		}
+	}
+}
+
+// console is the default handler: "[Tag] message" lines, colored by level
+// on a terminal.
+type console struct{}
+
+func (console) Enabled(_ context.Context, l slog.Level) bool {
+	return l >= level.Level()
+}
+
+func (console) Handle(_ context.Context, r slog.Record) error {
+	var tag, prefix string
+	var success bool
+	r.Attrs(func(a slog.Attr) bool {
+		switch a.Key {
+		case "tag":
+			tag = a.Value.String()
+		case "prefix":
+			prefix = a.Value.String()
+		case "success":
+			success = a.Value.Bool()
+		}
+		return true
+	})
+
+	msg := r.Message
+	if prefix != "" {
+		msg = prefix + ": " + msg
+	}
+
+	mark, code := "", ""
+	switch {
+	case r.Level >= slog.LevelError:
+		mark, code = "✗ ", "31"
+	case r.Level >= slog.LevelWarn:
+		mark, code = "⚠ ", "33"
+	case success:
+		mark, code = "✓ ", "32"
+	case r.Level < slog.LevelInfo:
+		code = "90"
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	line := fmt.Sprintf("[%s] %s%s", tag, mark, msg)
+	if color && code != "" {
+		line = "\x1b[" + code + "m" + line + "\x1b[0m"
+	}
+	clearStatus()
+	fmt.Fprintln(out, line)
+	drawStatus()
+	return nil
+}
+
+func (c console) WithAttrs([]slog.Attr) slog.Handler { return c }
+func (c console) WithGroup(string) slog.Handler      { return c }
+
+// Ask prints a yes/no question and reads the answer from stdin. Other
+// output waits until the question is answered.
+func Ask(question string) bool {
+	mu.Lock()
+	defer mu.Unlock()
+	clearStatus()
+	fmt.Fprintf(out, "[?] %s [y/N] ", question)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	drawStatus()
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
+
+// Restore leaves the terminal on a clean line when progress output is cut
+// short, e.g. by Ctrl+C.
+func Restore() {
+	mu.Lock()
+	defer mu.Unlock()
+	clearStatus()
+	progress = map[string]string{}
+	if inline > 0 {
+		fmt.Fprintln(out)
+		inline = 0
+	}
+}
+
+func clearStatus() {
+	if shown > 0 {
+		fmt.Fprintf(out, "\r%s\r", strings.Repeat(" ", shown))
+		shown = 0
+	}
+}
+
+func drawStatus() {
+	if len(progress) == 0 {
+		return
+	}
+	keys := make([]string, 0, len(progress))
+	for k := range progress {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, len(keys))
+	for i, k := range keys {
+		parts[i] = k + " " + progress[k]
+	}
+	line := "Progress: " + strings.Join(parts, " | ")
+	fmt.Fprint(out, line)
+	shown = len([]rune(line))
+}
+
+// Logger prefixes messages with the job they belong to. A Logger with
+// Shared set reports progress on the shared status line instead of taking
+// over the terminal line with Inline. Job names the job in the log file
+// even when nothing is shown on the console. Hook sees every message the
+// job logs, whatever the level. A Logger with a Handler logs only there,
+// leaving the console and the log file alone.
+type Logger struct {
+	Prefix  string
+	Shared  bool
+	Job     string
+	Hook    func(level slog.Level, tag, msg string)
+	Handler slog.Handler
+}
+
+// WithHook returns a copy of l that also passes every message to hook.
+func (l *Logger) WithHook(hook func(level slog.Level, tag, msg string)) *Logger {
+	c := l.copy()
+	c.Hook = hook
+	return c
+}
+
+// WithJob returns a copy of l that names job in the log file.
+func (l *Logger) WithJob(job string) *Logger {
+	c := l.copy()
+	c.Job = job
+	return c
+}
+
+func (l *Logger) copy() *Logger {
+	c := &Logger{}
+	if l != nil {
+		*c = *l
+	}
+	return c
+}
+
+func (l *Logger) log(lvl slog.Level, tag, msg string) {
+	if l != nil && l.Hook != nil {
+		l.Hook(lvl, tag, msg)
+	}
+	write(lvl, l, tag, msg, false)
+}
+
+// Enabled reports whether messages at lvl are shown on the console, or
+// by the Logger's Handler.
+func (l *Logger) Enabled(lvl slog.Level) bool {
+	if l != nil && l.Handler != nil {
+		return l.Handler.Enabled(context.Background(), lvl)
+	}
+	return lvl >= Level()
+}
+
+func (l *Logger) Debug(tag, msg string) { l.log(slog.LevelDebug, tag, msg) }
+func (l *Logger) Info(tag, msg string)  { l.log(slog.LevelInfo, tag, msg) }
+func (l *Logger) Warn(tag, msg string)  { l.log(slog.LevelWarn, tag, msg) }
+func (l *Logger) Error(tag, msg string) { l.log(slog.LevelError, tag, msg) }
+
+// Writer returns a writer that logs each line written to it at level,
+// for FFmpeg's stderr.
+func (l *Logger) Writer(tag string, level slog.Level) io.Writer {
+	return &lineWriter{log: l, tag: tag, level: level}
+}
+
+type lineWriter struct {
+	log   *Logger
+	tag   string
+	level slog.Level
+	buf   []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		if line := strings.TrimSpace(string(w.buf[:i])); line != "" {
+			w.log.log(w.level, w.tag, line)
+		}
+		w.buf = w.buf[i+1:]
+	}
+	return len(p), nil
+}
+
+func (l *Logger) Progress(msg string) {
+	if l == nil || !l.Shared {
+		Inline("Progress: " + msg)
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	progress[l.Prefix] = msg
+	clearStatus()
+	drawStatus()
+}
+
+func (l *Logger) EndProgress() {
+	if l == nil || !l.Shared {
+		mu.Lock()
+		defer mu.Unlock()
+		if inline > 0 {
+			fmt.Fprintln(out)
+		}
+		inline = 0
+		return
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	delete(progress, l.Prefix)
+	clearStatus()
+	drawStatus()
 }
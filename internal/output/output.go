@@ -0,0 +1,143 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+type Policy string
+
+const (
+	Always Policy = "always"
+	Never  Policy = "never"
+	Ask    Policy = "ask"
+	Rename Policy = "rename"
+)
+
+var ErrExists = errors.New("output file already exists")
+
+func ParsePolicy(s string) (Policy, error) {
+	switch p := Policy(s); p {
+	case Always, Never, Ask, Rename:
+		return p, nil
+	}
+	return "", fmt.Errorf("unknown overwrite policy: %s (use always, never, ask or rename)", s)
+}
+
+// Fields are the values a -name template can refer to.
+type Fields struct {
+	Input   string
+	Width   int
+	Height  int
+	Codec   string
+	Profile string
+	Mode    string
+}
+
+var placeholder = regexp.MustCompile(`\{([a-z]+)\}`)
+
+var names = []string{"base", "ext", "inext", "width", "height", "res", "codec", "profile", "mode"}
+
+func Validate(template string) error {
+	for _, m := range placeholder.FindAllStringSubmatch(template, -1) {
+		known := false
+		for _, n := range names {
+			if m[1] == n {
+				known = true
+				break
+			}
+		}
+		if !known {
+			return fmt.Errorf("unknown name placeholder {%s} (use %s)", m[1], "{"+strings.Join(names, "}, {")+"}")
+		}
+	}
+	return nil
+}
+
+func Format(template string, f Fields) string {
+	values := map[string]string{
+		"base":    Base(f.Input),
+		"ext":     "mp4",
+		"inext":   strings.TrimPrefix(filepath.Ext(f.Input), "."),
+		"width":   fmt.Sprint(f.Width),
+		"height":  fmt.Sprint(f.Height),
+		"res":     fmt.Sprintf("%dx%d", f.Width, f.Height),
+		"codec":   f.Codec,
+		"profile": f.Profile,
+		"mode":    f.Mode,
+	}
+	return placeholder.ReplaceAllStringFunc(template, func(m string) string {
+		if v, ok := values[m[1:len(m)-1]]; ok {
+			return v
+		}
+		return m
+	})
+}
+
+// Base is the file name of path without its extension.
+func Base(path string) string {
+	name := filepath.Base(path)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// Resolve applies the overwrite policy to path. It returns the path to
+// write to, or ErrExists when the existing file must be left alone.
+// confirm is asked under the Ask policy; without it nothing is overwritten.
+func Resolve(path string, policy Policy, confirm func(path string) bool) (string, error) {
+	if !exists(path) {
+		return path, nil
+	}
+
+	switch policy {
+	case Never:
+		return "", ErrExists
+	case Ask:
+		if confirm == nil || !confirm(path) {
+			return "", ErrExists
+		}
+	case Rename:
+		ext := filepath.Ext(path)
+		stem := strings.TrimSuffix(path, ext)
+		for n := 1; ; n++ {
+			candidate := fmt.Sprintf("%s-%d%s", stem, n, ext)
+			if !exists(candidate) {
+				return candidate, nil
+			}
+		}
+	}
+	return path, nil
+}
+
+// Temp is where the output is written until it is complete. It lives in
+// the same directory so the final rename stays on one file system.
+func Temp(path string) string {
+	dir, name := filepath.Split(path)
+	ext := filepath.Ext(name)
+	return filepath.Join(dir, "."+strings.TrimSuffix(name, ext)+".partial"+ext)
+}
+
+func exists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// Same reports whether a and b name the same file, so an output never
+// replaces its own input.
+func Same(a, b string) bool {
+	if filepath.Clean(a) == filepath.Clean(b) {
+		return true
+	}
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+	return os.SameFile(ai, bi)
+}
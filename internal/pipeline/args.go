@@ -0,0 +1,124 @@
+package pipeline
+
+import (
+	"strconv"
+
+	"kiourin-studio/video-resolution/internal/encoder"
+)
+
+const vaapiDevice = "/dev/dri/renderD128"
+
+type Job struct {
+	Input   string
+	Output  string
+	Encoder encoder.Config
+	Filters []Filter
+
+	// Seek and Length cut a segment out of the input, in seconds. NoAudio
+	// drops the audio, for segments that get their audio back on concat.
+	Seek    float64
+	Length  float64
+	NoAudio bool
+
+	// LogLevel is FFmpeg's -loglevel, "error" when empty.
+	LogLevel string
+}
+
+func (j Job) Accel() Accel {
+	return AccelFor(j.Encoder.Codec)
+}
+
+func Args(j Job) []string {
+	accel := j.Accel()
+
+	args := []string{"-y"}
+	args = append(args, hwInputArgs(accel)...)
+	if j.Seek > 0 {
+		args = append(args, "-ss", formatSeconds(j.Seek))
+	}
+	args = append(args, "-i", j.Input)
+	if j.Length > 0 {
+		args = append(args, "-t", formatSeconds(j.Length))
+	}
+
+	if chain := Chain(accel, j.Filters); chain != "" {
+		args = append(args, "-vf", chain)
+	}
+
+	args = append(args, "-c:v", j.Encoder.Codec)
+	args = append(args, j.Encoder.Params...)
+
+	if accel == Software {
+		args = append(args, "-pix_fmt", "yuv420p")
+	}
+
+	if j.NoAudio {
+		args = append(args, "-an")
+	} else {
+		args = append(args, "-c:a", "copy")
+	}
+
+	args = append(args,
+		"-movflags", "+faststart",
+		"-progress", "pipe:1",
+		"-nostats",
+		"-loglevel", j.logLevel(),
+		j.Output,
+	)
+	return args
+}
+
+func (j Job) logLevel() string {
+	if j.LogLevel == "" {
+		return "error"
+	}
+	return j.LogLevel
+}
+
+// ConcatArgs joins the segments listed in a concat demuxer file and takes
+// the audio, untouched and in one piece, from the original input.
+func ConcatArgs(list, audioSource, output string) []string {
+	return []string{
+		"-y",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", list,
+		"-i", audioSource,
+		"-map", "0:v",
+		"-map", "1:a?",
+		"-c", "copy",
+		"-movflags", "+faststart",
+		"-loglevel", "error",
+		output,
+	}
+}
+
+func formatSeconds(s float64) string {
+	return strconv.FormatFloat(s, 'f', 3, 64)
+}
+
+func hwInputArgs(accel Accel) []string {
+	var device string
+	format := string(accel)
+	switch accel {
+	case CUDA:
+		device = "cuda=hw"
+	case QSV:
+		device = "qsv=hw"
+	case VAAPI:
+		device = "vaapi=hw:" + vaapiDevice
+	case D3D11:
+		device = "d3d11va=hw"
+		format = "d3d11"
+	default:
+		return nil
+	}
+
+	return []string{
+		"-init_hw_device", device,
+		"-filter_hw_device", "hw",
+		"-hwaccel", string(accel),
+		"-hwaccel_device", "hw",
+		"-hwaccel_output_format", format,
+	}
+}
@@ -0,0 +1,134 @@
+package pipeline
+
+import (
+	"fmt"
+	"strings"
+
+	"kiourin-studio/video-resolution/internal/encoder"
+)
+
+type Enhancement string
+
+const (
+	Denoise Enhancement = "denoise"
+	Deband  Enhancement = "deband"
+	Sharpen Enhancement = "sharpen"
+)
+
+func ParseEnhancements(s string) ([]Enhancement, error) {
+	var out []Enhancement
+	for _, name := range strings.Split(s, ",") {
+		e := Enhancement(strings.TrimSpace(strings.ToLower(name)))
+		switch e {
+		case Denoise, Deband, Sharpen:
+			out = append(out, e)
+		case "":
+		default:
+			return nil, fmt.Errorf("unknown enhancement: %s", name)
+		}
+	}
+	return out, nil
+}
+
+// PreScale returns the enhancements that must run on the source frames:
+// noise and banding are cheaper to remove, and easier to detect, before
+// they are scaled. Denoise always goes first so deband sees clean input.
+func PreScale(enh []Enhancement, profile encoder.Profile) []Filter {
+	var filters []Filter
+	if has(enh, Denoise) {
+		filters = append(filters, denoiseFilter(profile))
+	}
+	if has(enh, Deband) {
+		filters = append(filters, debandFilter(profile))
+	}
+	return filters
+}
+
+// PostScale returns the enhancements that run on the scaled frames.
+func PostScale(enh []Enhancement, profile encoder.Profile) []Filter {
+	var filters []Filter
+	if has(enh, Sharpen) {
+		filters = append(filters, sharpenFilter(profile))
+	}
+	return filters
+}
+
+func has(enh []Enhancement, e Enhancement) bool {
+	for _, x := range enh {
+		if x == e {
+			return true
+		}
+	}
+	return false
+}
+
+func denoiseFilter(profile encoder.Profile) Filter {
+	switch profile {
+	case encoder.Low:
+		return Filter{
+			Soft: "hqdn3d=2:1.5:3:2.25",
+			HW: map[Accel]string{
+				QSV:   "vpp_qsv=denoise=15",
+				VAAPI: "denoise_vaapi=denoise=15",
+			},
+		}
+	case encoder.High:
+		return Filter{
+			Soft: "nlmeans=s=3:p=7:r=15",
+			HW: map[Accel]string{
+				QSV:   "vpp_qsv=denoise=40",
+				VAAPI: "denoise_vaapi=denoise=40",
+			},
+		}
+	default:
+		return Filter{
+			Soft: "hqdn3d=4:3:6:4.5",
+			HW: map[Accel]string{
+				QSV:   "vpp_qsv=denoise=25",
+				VAAPI: "denoise_vaapi=denoise=25",
+			},
+		}
+	}
+}
+
+func debandFilter(profile encoder.Profile) Filter {
+	threshold := "0.03"
+	switch profile {
+	case encoder.Low:
+		threshold = "0.02"
+	case encoder.High:
+		threshold = "0.04"
+	}
+	return Filter{
+		Soft: fmt.Sprintf("deband=1thr=%[1]s:2thr=%[1]s:3thr=%[1]s:4thr=%[1]s:blur=1", threshold),
+	}
+}
+
+func sharpenFilter(profile encoder.Profile) Filter {
+	switch profile {
+	case encoder.Low:
+		return Filter{
+			Soft: "unsharp=5:5:0.5:5:5:0",
+			HW: map[Accel]string{
+				QSV:   "vpp_qsv=detail=20",
+				VAAPI: "sharpness_vaapi=sharpness=20",
+			},
+		}
+	case encoder.High:
+		return Filter{
+			Soft: "cas=strength=0.7",
+			HW: map[Accel]string{
+				QSV:   "vpp_qsv=detail=50",
+				VAAPI: "sharpness_vaapi=sharpness=50",
+			},
+		}
+	default:
+		return Filter{
+			Soft: "cas=strength=0.5",
+			HW: map[Accel]string{
+				QSV:   "vpp_qsv=detail=35",
+				VAAPI: "sharpness_vaapi=sharpness=35",
+			},
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package pipeline
+
+import (
+	"fmt"
+	"runtime"
+	"strings"
+)
+
+type Accel string
+
+const (
+	Software Accel = ""
+	CUDA     Accel = "cuda"
+	QSV      Accel = "qsv"
+	VAAPI    Accel = "vaapi"
+	D3D11    Accel = "d3d11va"
+)
+
+// AccelFor returns the hardware pipeline that feeds codec. AMF takes
+// D3D11 frames on Windows and goes through VAAPI elsewhere.
+func AccelFor(codec string) Accel {
+	switch {
+	case strings.HasSuffix(codec, "_nvenc"):
+		return CUDA
+	case strings.HasSuffix(codec, "_qsv"):
+		return QSV
+	case strings.HasSuffix(codec, "_vaapi"):
+		return VAAPI
+	case strings.HasSuffix(codec, "_amf"):
+		if runtime.GOOS == "windows" {
+			return D3D11
+		}
+		return VAAPI
+	default:
+		return Software
+	}
+}
+
+// Filter is a single video filter with optional GPU equivalents. Filters
+// without an entry for the active Accel run on the CPU.
+type Filter struct {
+	Soft string
+	HW   map[Accel]string
+
+	// Converts is set when the GPU variants also leave frames in the
+	// 8-bit format the encoders take, as scaling does.
+	Converts bool
+}
+
+// Decoded frames stay in the source's format on the GPU, which is 10-bit
+// (p010) for some sources. The hardware H.264 encoders only take 8-bit, so
+// frames are converted on the GPU, into gpuFormat's software format. D3D11
+// has no conversion filter and leaves the frames as they are.
+var (
+	gpuFormat = map[Accel]string{
+		CUDA:  "scale_cuda=format=yuv420p",
+		QSV:   "vpp_qsv=format=nv12",
+		VAAPI: "scale_vaapi=format=nv12",
+	}
+	swFormat = map[Accel]string{
+		CUDA:  "yuv420p",
+		QSV:   "nv12",
+		VAAPI: "nv12",
+		D3D11: "nv12",
+	}
+)
+
+func Scale(w, h int) Filter {
+	return Filter{
+		Soft: fmt.Sprintf("scale=%d:%d:flags=lanczos", w, h),
+		HW: map[Accel]string{
+			CUDA:  fmt.Sprintf("scale_cuda=w=%d:h=%d:interp_algo=lanczos:format=yuv420p", w, h),
+			QSV:   fmt.Sprintf("scale_qsv=w=%d:h=%d:format=nv12", w, h),
+			VAAPI: fmt.Sprintf("scale_vaapi=w=%d:h=%d:format=nv12", w, h),
+		},
+		Converts: true,
+	}
+}
+
+func Chain(accel Accel, filters []Filter) string {
+	var parts []string
+	onGPU := accel != Software
+	converted := false
+
+	for _, f := range filters {
+		// Frames go back to the GPU for a filter that has a GPU variant.
+		// They were downloaded as 8-bit, so they need no conversion.
+		if hw, ok := f.HW[accel]; ok && !onGPU {
+			parts = append(parts, "format=nv12", "hwupload", hw)
+			onGPU, converted = true, true
+			continue
+		}
+		if onGPU {
+			if hw, ok := f.HW[accel]; ok {
+				parts = append(parts, hw)
+				converted = converted || f.Converts
+				continue
+			}
+			if conv, ok := gpuFormat[accel]; ok && !converted {
+				parts = append(parts, conv)
+			}
+			parts = append(parts, "hwdownload", "format="+swFormat[accel])
+			onGPU = false
+		}
+		parts = append(parts, f.Soft)
+	}
+
+	if conv, ok := gpuFormat[accel]; ok && onGPU && !converted {
+		parts = append(parts, conv)
+	}
+	if accel != Software && !onGPU {
+		parts = append(parts, "format=nv12", "hwupload")
+	}
+
+	return strings.Join(parts, ",")
+}
+
+// SuperResolution runs a DNN model through the sr filter. It has no GPU
+// variant, so hardware pipelines download frames around it.
+func SuperResolution(model string, srcnn bool, factor int) Filter {
+	f := fmt.Sprintf("format=yuv420p,sr=dnn_backend=tensorflow:model=%s", escape(model))
+	if srcnn {
+		f += fmt.Sprintf(":scale_factor=%d", factor)
+	}
+	return Filter{Soft: f}
+}
+
+func escape(path string) string {
+	path = strings.ReplaceAll(path, `\`, "/")
+	r := strings.NewReplacer(":", `\\:`, ",", `\,`, ";", `\;`, "[", `\[`, "]", `\]`)
+	return r.Replace(path)
+}
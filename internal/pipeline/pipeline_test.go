@@ -0,0 +1,142 @@
+package pipeline
+
+import (
+	"runtime"
+	"slices"
+	"testing"
+
+	"kiourin-studio/video-resolution/internal/encoder"
+)
+
+var deband = debandFilter(encoder.Med)
+
+func TestChain(t *testing.T) {
+	tests := []struct {
+		name    string
+		accel   Accel
+		filters []Filter
+		want    string
+	}{
+		{"software", Software, nil, ""},
+		{"software scale", Software, []Filter{Scale(1280, 720)}, "scale=1280:720:flags=lanczos"},
+		{"software cpu filter", Software, []Filter{deband}, deband.Soft},
+
+		{"cuda", CUDA, nil, "scale_cuda=format=yuv420p"},
+		{"cuda scale", CUDA, []Filter{Scale(1280, 720)},
+			"scale_cuda=w=1280:h=720:interp_algo=lanczos:format=yuv420p"},
+		{"cuda cpu filter", CUDA, []Filter{deband},
+			"scale_cuda=format=yuv420p,hwdownload,format=yuv420p," + deband.Soft + ",format=nv12,hwupload"},
+		{"cuda scale cpu filter", CUDA, []Filter{Scale(1280, 720), deband},
+			"scale_cuda=w=1280:h=720:interp_algo=lanczos:format=yuv420p,hwdownload,format=yuv420p," + deband.Soft + ",format=nv12,hwupload"},
+		{"cuda cpu filter scale", CUDA, []Filter{deband, Scale(1280, 720)},
+			"scale_cuda=format=yuv420p,hwdownload,format=yuv420p," + deband.Soft + ",format=nv12,hwupload,scale_cuda=w=1280:h=720:interp_algo=lanczos:format=yuv420p"},
+
+		{"qsv", QSV, nil, "vpp_qsv=format=nv12"},
+		{"qsv scale", QSV, []Filter{Scale(1280, 720)}, "scale_qsv=w=1280:h=720:format=nv12"},
+		{"qsv gpu filter", QSV, []Filter{denoiseFilter(encoder.Med)}, "vpp_qsv=denoise=25,vpp_qsv=format=nv12"},
+		{"qsv cpu filter", QSV, []Filter{deband},
+			"vpp_qsv=format=nv12,hwdownload,format=nv12," + deband.Soft + ",format=nv12,hwupload"},
+		{"qsv scale cpu filter", QSV, []Filter{Scale(1280, 720), deband},
+			"scale_qsv=w=1280:h=720:format=nv12,hwdownload,format=nv12," + deband.Soft + ",format=nv12,hwupload"},
+
+		{"vaapi", VAAPI, nil, "scale_vaapi=format=nv12"},
+		{"vaapi scale", VAAPI, []Filter{Scale(1280, 720)}, "scale_vaapi=w=1280:h=720:format=nv12"},
+		{"vaapi cpu filter", VAAPI, []Filter{deband},
+			"scale_vaapi=format=nv12,hwdownload,format=nv12," + deband.Soft + ",format=nv12,hwupload"},
+		{"vaapi scale cpu filter", VAAPI, []Filter{Scale(1280, 720), deband},
+			"scale_vaapi=w=1280:h=720:format=nv12,hwdownload,format=nv12," + deband.Soft + ",format=nv12,hwupload"},
+		{"vaapi cpu filter gpu filter", VAAPI, []Filter{deband, denoiseFilter(encoder.Med)},
+			"scale_vaapi=format=nv12,hwdownload,format=nv12," + deband.Soft + ",format=nv12,hwupload,denoise_vaapi=denoise=25"},
+
+		{"d3d11", D3D11, nil, ""},
+		{"d3d11 scale", D3D11, []Filter{Scale(1280, 720)},
+			"hwdownload,format=nv12,scale=1280:720:flags=lanczos,format=nv12,hwupload"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Chain(tt.accel, tt.filters); got != tt.want {
+				t.Errorf("Chain() =\n  %s\nwant\n  %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestArgs(t *testing.T) {
+	hw := func(device, accel string) []string {
+		return []string{
+			"-init_hw_device", device,
+			"-filter_hw_device", "hw",
+			"-hwaccel", accel,
+			"-hwaccel_device", "hw",
+			"-hwaccel_output_format", accel,
+		}
+	}
+	// AMF goes through D3D11 on Windows and VAAPI elsewhere.
+	amf := [][]string{{"-y"}, hw("vaapi=hw:"+vaapiDevice, "vaapi"), {"-i", "in.mov", "-vf", "scale_vaapi=format=nv12", "-c:v", "h264_amf"}}
+	if runtime.GOOS == "windows" {
+		amf = [][]string{{"-y"}, hw("d3d11va=hw", "d3d11va"), {"-i", "in.mov", "-c:v", "h264_amf"}}
+		amf[1][len(amf[1])-1] = "d3d11"
+	}
+
+	tail := []string{
+		"-c:a", "copy",
+		"-movflags", "+faststart",
+		"-progress", "pipe:1",
+		"-nostats",
+		"-loglevel", "error",
+		"out.mp4",
+	}
+
+	tests := []struct {
+		name    string
+		codec   string
+		filters []Filter
+		want    [][]string
+	}{
+		{"software", "libx264", nil, [][]string{
+			{"-y", "-i", "in.mov", "-c:v", "libx264", "-pix_fmt", "yuv420p"},
+		}},
+		{"software cpu filter", "libx264", []Filter{deband}, [][]string{
+			{"-y", "-i", "in.mov", "-vf", deband.Soft, "-c:v", "libx264", "-pix_fmt", "yuv420p"},
+		}},
+		{"amf", "h264_amf", nil, amf},
+		{"cuda", "h264_nvenc", nil, [][]string{
+			{"-y"}, hw("cuda=hw", "cuda"),
+			{"-i", "in.mov", "-vf", "scale_cuda=format=yuv420p", "-c:v", "h264_nvenc"},
+		}},
+		{"cuda cpu filter", "h264_nvenc", []Filter{deband}, [][]string{
+			{"-y"}, hw("cuda=hw", "cuda"),
+			{"-i", "in.mov", "-vf", "scale_cuda=format=yuv420p,hwdownload,format=yuv420p," + deband.Soft + ",format=nv12,hwupload", "-c:v", "h264_nvenc"},
+		}},
+		{"qsv", "h264_qsv", nil, [][]string{
+			{"-y"}, hw("qsv=hw", "qsv"),
+			{"-i", "in.mov", "-vf", "vpp_qsv=format=nv12", "-c:v", "h264_qsv"},
+		}},
+		{"qsv cpu filter", "h264_qsv", []Filter{deband}, [][]string{
+			{"-y"}, hw("qsv=hw", "qsv"),
+			{"-i", "in.mov", "-vf", "vpp_qsv=format=nv12,hwdownload,format=nv12," + deband.Soft + ",format=nv12,hwupload", "-c:v", "h264_qsv"},
+		}},
+		{"vaapi", "h264_vaapi", nil, [][]string{
+			{"-y"}, hw("vaapi=hw:"+vaapiDevice, "vaapi"),
+			{"-i", "in.mov", "-vf", "scale_vaapi=format=nv12", "-c:v", "h264_vaapi"},
+		}},
+		{"vaapi cpu filter", "h264_vaapi", []Filter{deband}, [][]string{
+			{"-y"}, hw("vaapi=hw:"+vaapiDevice, "vaapi"),
+			{"-i", "in.mov", "-vf", "scale_vaapi=format=nv12,hwdownload,format=nv12," + deband.Soft + ",format=nv12,hwupload", "-c:v", "h264_vaapi"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Args(Job{
+				Input:   "in.mov",
+				Output:  "out.mp4",
+				Encoder: encoder.Config{Codec: tt.codec},
+				Filters: tt.filters,
+			})
+			want := slices.Concat(append(tt.want, tail)...)
+			if !slices.Equal(got, want) {
+				t.Errorf("Args() =\n  %q\nwant\n  %q", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,286 @@
+package probe
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// MediaInfo is what ffprobe reports about a file: its container, every
+// stream and the chapters.
+type MediaInfo struct {
+	Format   Format    `json:"format"`
+	Streams  []Stream  `json:"streams"`
+	Chapters []Chapter `json:"chapters,omitempty"`
+}
+
+type Format struct {
+	Name     string `json:"name"`
+	LongName string `json:"long_name,omitempty"`
+	// StartTime is the first timestamp in the file. It is not 0 for
+	// MPEG-TS and some MP4s, and seeking with -ss is relative to it.
+	StartTime float64           `json:"start_time,omitempty"`
+	Duration  float64           `json:"duration,omitempty"`
+	Size      int64             `json:"size,omitempty"`
+	Bitrate   int64             `json:"bitrate,omitempty"`
+	Tags      map[string]string `json:"tags,omitempty"`
+}
+
+type Stream struct {
+	Index     int     `json:"index"`
+	Type      string  `json:"type"` // video, audio, subtitle, data, attachment
+	Codec     string  `json:"codec"`
+	CodecLong string  `json:"codec_long,omitempty"`
+	Profile   string  `json:"profile,omitempty"`
+	Bitrate   int64   `json:"bitrate,omitempty"`
+	StartTime float64 `json:"start_time,omitempty"`
+	Duration  float64 `json:"duration,omitempty"`
+	Frames    int64   `json:"frames,omitempty"`
+	Language  string  `json:"language,omitempty"`
+	Title     string  `json:"title,omitempty"`
+
+	// Video
+	Width          int     `json:"width,omitempty"`
+	Height         int     `json:"height,omitempty"`
+	PixFmt         string  `json:"pix_fmt,omitempty"`
+	FrameRate      float64 `json:"frame_rate,omitempty"`
+	SAR            string  `json:"sar,omitempty"`
+	DAR            string  `json:"dar,omitempty"`
+	Rotation       int     `json:"rotation,omitempty"`
+	ColorRange     string  `json:"color_range,omitempty"`
+	ColorSpace     string  `json:"color_space,omitempty"`
+	ColorTransfer  string  `json:"color_transfer,omitempty"`
+	ColorPrimaries string  `json:"color_primaries,omitempty"`
+
+	// Audio
+	SampleRate    int    `json:"sample_rate,omitempty"`
+	Channels      int    `json:"channels,omitempty"`
+	ChannelLayout string `json:"channel_layout,omitempty"`
+
+	// Disposition holds the flags ffprobe sets, e.g. "default",
+	// "forced" or "attached_pic".
+	Disposition map[string]bool   `json:"disposition,omitempty"`
+	Tags        map[string]string `json:"tags,omitempty"`
+}
+
+type Chapter struct {
+	ID    int64   `json:"id"`
+	Start float64 `json:"start"`
+	End   float64 `json:"end"`
+	Title string  `json:"title,omitempty"`
+}
+
+var ErrNoVideo = errors.New("no video stream")
+
+// Probe runs ffprobe once on path and returns everything it reports.
+func Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	out, err := run(ctx,
+		"-v", "error",
+		"-show_format",
+		"-show_streams",
+		"-show_chapters",
+		"-of", "json",
+		path,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(out)
+}
+
+// Parse decodes ffprobe's JSON output.
+func Parse(data []byte) (*MediaInfo, error) {
+	var raw rawInfo
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("cannot parse ffprobe output: %w", err)
+	}
+
+	info := &MediaInfo{
+		Format: Format{
+			Name:      raw.Format.FormatName,
+			LongName:  raw.Format.FormatLongName,
+			StartTime: parseFloat(raw.Format.StartTime),
+			Duration:  parseFloat(raw.Format.Duration),
+			Size:      parseInt(raw.Format.Size),
+			Bitrate:   parseInt(raw.Format.BitRate),
+			Tags:      raw.Format.Tags,
+		},
+	}
+	for _, s := range raw.Streams {
+		info.Streams = append(info.Streams, s.stream())
+	}
+	for _, c := range raw.Chapters {
+		info.Chapters = append(info.Chapters, Chapter{
+			ID:    c.ID,
+			Start: parseFloat(c.StartTime),
+			End:   parseFloat(c.EndTime),
+			Title: c.Tags["title"],
+		})
+	}
+	return info, nil
+}
+
+// Video is the main video stream: the first one that is not cover art.
+func (m *MediaInfo) Video() (Stream, error) {
+	for _, s := range m.Streams {
+		if s.Type == "video" && !s.Disposition["attached_pic"] {
+			return s, nil
+		}
+	}
+	return Stream{}, ErrNoVideo
+}
+
+// Duration of the file in seconds, from the container or else the video
+// stream. It is 0 when neither knows.
+func (m *MediaInfo) Duration() float64 {
+	if m.Format.Duration > 0 {
+		return m.Format.Duration
+	}
+	if v, err := m.Video(); err == nil {
+		return v.Duration
+	}
+	return 0
+}
+
+// Streams of one type, in file order.
+func (m *MediaInfo) StreamsOf(typ string) []Stream {
+	var out []Stream
+	for _, s := range m.Streams {
+		if s.Type == typ {
+			out = append(out, s)
+		}
+	}
+	return out
+}
+
+// rawInfo mirrors ffprobe's JSON, which has most numbers as strings.
+type rawInfo struct {
+	Format struct {
+		FormatName     string            `json:"format_name"`
+		FormatLongName string            `json:"format_long_name"`
+		StartTime      string            `json:"start_time"`
+		Duration       string            `json:"duration"`
+		Size           string            `json:"size"`
+		BitRate        string            `json:"bit_rate"`
+		Tags           map[string]string `json:"tags"`
+	} `json:"format"`
+	Streams  []rawStream `json:"streams"`
+	Chapters []struct {
+		ID        int64             `json:"id"`
+		StartTime string            `json:"start_time"`
+		EndTime   string            `json:"end_time"`
+		Tags      map[string]string `json:"tags"`
+	} `json:"chapters"`
+}
+
+type rawStream struct {
+	Index              int               `json:"index"`
+	CodecType          string            `json:"codec_type"`
+	CodecName          string            `json:"codec_name"`
+	CodecLongName      string            `json:"codec_long_name"`
+	Profile            string            `json:"profile"`
+	Width              int               `json:"width"`
+	Height             int               `json:"height"`
+	PixFmt             string            `json:"pix_fmt"`
+	SampleAspectRatio  string            `json:"sample_aspect_ratio"`
+	DisplayAspectRatio string            `json:"display_aspect_ratio"`
+	AvgFrameRate       string            `json:"avg_frame_rate"`
+	RFrameRate         string            `json:"r_frame_rate"`
+	ColorRange         string            `json:"color_range"`
+	ColorSpace         string            `json:"color_space"`
+	ColorTransfer      string            `json:"color_transfer"`
+	ColorPrimaries     string            `json:"color_primaries"`
+	SampleRate         string            `json:"sample_rate"`
+	Channels           int               `json:"channels"`
+	ChannelLayout      string            `json:"channel_layout"`
+	BitRate            string            `json:"bit_rate"`
+	StartTime          string            `json:"start_time"`
+	Duration           string            `json:"duration"`
+	NbFrames           string            `json:"nb_frames"`
+	Disposition        map[string]int    `json:"disposition"`
+	Tags               map[string]string `json:"tags"`
+	SideDataList       []struct {
+		Rotation float64 `json:"rotation"`
+	} `json:"side_data_list"`
+}
+
+func (r rawStream) stream() Stream {
+	s := Stream{
+		Index:          r.Index,
+		Type:           r.CodecType,
+		Codec:          r.CodecName,
+		CodecLong:      r.CodecLongName,
+		Profile:        r.Profile,
+		Bitrate:        parseInt(r.BitRate),
+		StartTime:      parseFloat(r.StartTime),
+		Duration:       parseFloat(r.Duration),
+		Frames:         parseInt(r.NbFrames),
+		Language:       r.Tags["language"],
+		Title:          r.Tags["title"],
+		Width:          r.Width,
+		Height:         r.Height,
+		PixFmt:         r.PixFmt,
+		SAR:            r.SampleAspectRatio,
+		DAR:            r.DisplayAspectRatio,
+		ColorRange:     r.ColorRange,
+		ColorSpace:     r.ColorSpace,
+		ColorTransfer:  r.ColorTransfer,
+		ColorPrimaries: r.ColorPrimaries,
+		SampleRate:     int(parseInt(r.SampleRate)),
+		Channels:       r.Channels,
+		ChannelLayout:  r.ChannelLayout,
+		Tags:           r.Tags,
+	}
+
+	// avg_frame_rate is 0/0 for streams without a steady rate.
+	s.FrameRate = parseRate(r.AvgFrameRate)
+	if s.FrameRate == 0 {
+		s.FrameRate = parseRate(r.RFrameRate)
+	}
+
+	// Newer FFmpeg puts rotation in the display matrix, older in a tag.
+	for _, sd := range r.SideDataList {
+		if sd.Rotation != 0 {
+			s.Rotation = int(sd.Rotation)
+		}
+	}
+	if s.Rotation == 0 {
+		s.Rotation = int(parseInt(r.Tags["rotate"]))
+	}
+
+	if len(r.Disposition) > 0 {
+		s.Disposition = map[string]bool{}
+		for k, v := range r.Disposition {
+			if v != 0 {
+				s.Disposition[k] = true
+			}
+		}
+	}
+	return s
+}
+
+func parseFloat(s string) float64 {
+	f, _ := strconv.ParseFloat(s, 64)
+	return f
+}
+
+func parseInt(s string) int64 {
+	n, _ := strconv.ParseInt(s, 10, 64)
+	return n
+}
+
+// parseRate reads a rational like "30000/1001".
+func parseRate(s string) float64 {
+	num, den, ok := strings.Cut(s, "/")
+	if !ok {
+		return parseFloat(s)
+	}
+	d := parseFloat(den)
+	if d == 0 {
+		return 0
+	}
+	return parseFloat(num) / d
+}
@@ -1,52 +1,44 @@
 package probe
 
 import (
-	"bytes"
+	"context"
 	"os/exec"
 	"strconv"
 	"strings"
 )
 
-type Resolution struct {
-	W int
-	H int
+// run runs ffprobe and returns what it printed. A probe cut short by ctx
+// reports ctx's error rather than how ffprobe died.
+func run(ctx context.Context, args ...string) ([]byte, error) {
+	out, err := exec.CommandContext(ctx, "ffprobe", args...).Output()
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	return out, err
 }
 
-func ResolutionOf(path string) (Resolution, error) {
-	cmd := exec.Command(
-		"ffprobe",
+// Keyframes lists the presentation times of the keyframes of the first
+// video stream. Only keyframes are decoded, so this is fast even for long
+// files.
+func Keyframes(ctx context.Context, path string) ([]float64, error) {
+	out, err := run(ctx,
 		"-v", "error",
 		"-select_streams", "v:0",
-		"-show_entries", "stream=width,height",
-		"-of", "csv=p=0:s=x",
+		"-skip_frame", "nokey",
+		"-show_entries", "frame=pts_time",
+		"-of", "csv=p=0",
 		path,
 	)
-
-	var out bytes.Buffer
-	cmd.Stdout = &out
-	if err := cmd.Run(); err != nil {
-		return Resolution{}, err
+	if err != nil {
+		return nil, err
 	}
 
-	p := strings.Split(strings.TrimSpace(out.String()), "x")
-	w, _ := strconv.Atoi(p[0])
-	h, _ := strconv.Atoi(p[1])
-
-	return Resolution{W: w, H: h}, nil
-}
-
-func Duration(path string) (float64, error) {
-	cmd := exec.Command(
-		"ffprobe",
-		"-v", "error",
-		"-show_entries", "format=duration",
-		"-of", "default=noprint_wrappers=1:nokey=1",
-		path,
-	)
-
-	out, err := cmd.Output()
-	if err != nil {
-		return 0, err
+	var times []float64
+	for _, line := range strings.Split(string(out), "\n") {
+		t, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(line, ",")), 64)
+		if err == nil {
+			times = append(times, t)
+		}
 	}
-	return strconv.ParseFloat(strings.TrimSpace(string(out)), 64)
+	return times, nil
 }
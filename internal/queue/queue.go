@@ -0,0 +1,60 @@
+package queue
+
+import (
+	"runtime"
+	"sync"
+
+	"kiourin-studio/video-resolution/internal/ffmpeg"
+)
+
+// Pool is a group of workers bound to one encoding backend. Limit is the
+// number of jobs the backend may run at the same time.
+type Pool struct {
+	GPU   ffmpeg.GPU
+	Limit int
+}
+
+// DefaultLimit is the number of concurrent sessions a backend handles on
+// typical hardware. Consumer NVIDIA cards refuse more NVENC sessions than
+// the driver allows, and QSV/AMF/VAAPI gain nothing from a second session
+// on the same engine. CPU jobs are sized so threads times jobs fills the
+// machine without oversubscribing it.
+func DefaultLimit(gpu ffmpeg.GPU, threads int) int {
+	switch gpu {
+	case ffmpeg.NVIDIA:
+		return 2
+	case ffmpeg.CPU:
+		if threads <= 0 {
+			return 1
+		}
+		return max(1, runtime.NumCPU()/threads)
+	default:
+		return 1
+	}
+}
+
+// Run hands out the job indices 0..n-1 to the workers of all pools and
+// returns once every job has finished. Workers from different pools pull
+// from the same queue, so a hybrid batch keeps every backend busy.
+func Run(pools []Pool, n int, work func(i int, gpu ffmpeg.GPU)) {
+	jobs := make(chan int)
+	var wg sync.WaitGroup
+
+	for _, p := range pools {
+		for w := 0; w < max(p.Limit, 1); w++ {
+			wg.Add(1)
+			go func(gpu ffmpeg.GPU) {
+				defer wg.Done()
+				for i := range jobs {
+					work(i, gpu)
+				}
+			}(p.GPU)
+		}
+	}
+
+	for i := 0; i < n; i++ {
+		jobs <- i
+	}
+	close(jobs)
+	wg.Wait()
+}
@@ -0,0 +1,49 @@
+package scaler
+
+import "math"
+
+var Ladder = []int{360, 480, 540, 720, 1080, 1440, 2160}
+
+// Snap moves target to the nearest rung of the standard ladder that is
+// still in the direction of mode relative to source. Rungs are matched on
+// the short side, so portrait video snaps its width; the long side follows
+// the source aspect ratio. target is returned unchanged when no rung lies
+// in that direction.
+func Snap(source, target Resolution, mode string) Resolution {
+	portrait := source.H > source.W
+	srcShort, short := source.H, target.H
+	if portrait {
+		srcShort, short = source.W, target.W
+	}
+
+	best := 0
+	for _, rung := range Ladder {
+		if mode == "down" && rung >= srcShort {
+			continue
+		}
+		if mode == "up" && rung <= srcShort {
+			continue
+		}
+		if best == 0 || abs(rung-short) < abs(best-short) {
+			best = rung
+		}
+	}
+	if best == 0 {
+		return target
+	}
+
+	ratio := float64(source.W) / float64(source.H)
+	if portrait {
+		h := int(math.Round(float64(best) / ratio))
+		return Resolution{W: best, H: h - h%2}
+	}
+	w := int(math.Round(float64(best) * ratio))
+	return Resolution{W: w - w%2, H: best}
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -0,0 +1,66 @@
+package scaler
+
+import "math"
+
+type Limits struct {
+	MinW, MinH int
+	MaxW, MaxH int
+	Align      int
+}
+
+func (l Limits) Fits(r Resolution) bool {
+	align := max(l.Align, 2)
+	return r.W >= l.MinW && r.H >= l.MinH &&
+		r.W <= l.MaxW && r.H <= l.MaxH &&
+		r.W%align == 0 && r.H%align == 0
+}
+
+// Fit scales r uniformly into the limits, keeping the aspect ratio, and
+// rounds both sides to the required alignment.
+func Fit(r Resolution, l Limits) Resolution {
+	factor := 1.0
+	if r.W > l.MaxW || r.H > l.MaxH {
+		factor = math.Min(float64(l.MaxW)/float64(r.W), float64(l.MaxH)/float64(r.H))
+	} else if r.W < l.MinW || r.H < l.MinH {
+		factor = math.Max(float64(l.MinW)/float64(r.W), float64(l.MinH)/float64(r.H))
+	}
+
+	w := int(math.Round(float64(r.W) * factor))
+	h := int(math.Round(float64(r.H) * factor))
+
+	return clampAligned(Resolution{W: w, H: h}, l)
+}
+
+func Align(r Resolution, n int) Resolution {
+	if n < 2 {
+		n = 2
+	}
+	return Resolution{W: roundTo(r.W, n), H: roundTo(r.H, n)}
+}
+
+func clampAligned(r Resolution, l Limits) Resolution {
+	align := max(l.Align, 2)
+	r = Align(r, align)
+
+	for r.W > l.MaxW {
+		r.W -= align
+	}
+	for r.H > l.MaxH {
+		r.H -= align
+	}
+	for r.W < l.MinW {
+		r.W += align
+	}
+	for r.H < l.MinH {
+		r.H += align
+	}
+	return r
+}
+
+func roundTo(v, n int) int {
+	r := int(math.Round(float64(v)/float64(n))) * n
+	if r < n {
+		r = n
+	}
+	return r
+}
@@ -3,10 +3,12 @@ package scaler
 import "math"
 
 type Resolution struct {
-	W int
-	H int
+	W int `json:"width"`
+	H int `json:"height"`
 }
 
+const minLongSide = 320
+
 func Auto(res Resolution, mode string) Resolution {
 	ratio := float64(res.W) / float64(res.H)
 
@@ -21,9 +23,15 @@ func Auto(res Resolution, mode string) Resolution {
 	w -= w % 2
 	h -= h % 2
 
-	if w < 320 {
-		w = 320
-		h = int(float64(w) / ratio)
+	if max(w, h) < minLongSide {
+		if w >= h {
+			w = minLongSide
+			h = int(math.Round(float64(w) / ratio))
+		} else {
+			h = minLongSide
+			w = int(math.Round(float64(h) * ratio))
+		}
+		w -= w % 2
 		h -= h % 2
 	}
 
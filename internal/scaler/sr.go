@@ -0,0 +1,110 @@
+package scaler
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+type ModelKind string
+
+const (
+	ESPCN ModelKind = "espcn"
+	SRCNN ModelKind = "srcnn"
+)
+
+// Model is a super-resolution network usable by ffmpeg's sr filter.
+// ESPCN models upscale by a fixed Factor baked into the network; SRCNN
+// models refine a pre-upscaled frame and work with any integer factor.
+type Model struct {
+	Path   string
+	Kind   ModelKind
+	Factor int
+}
+
+var factorPattern = regexp.MustCompile(`(?i)x([2-4])`)
+
+func LoadModels(path string) ([]Model, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []string{path}
+	if info.IsDir() {
+		files, err = filepath.Glob(filepath.Join(path, "*.pb"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var models []Model
+	for _, f := range files {
+		name := strings.ToLower(filepath.Base(f))
+
+		m := Model{Path: f, Kind: ESPCN}
+		if strings.Contains(name, "srcnn") {
+			m.Kind = SRCNN
+		}
+		if match := factorPattern.FindStringSubmatch(name); match != nil {
+			m.Factor, _ = strconv.Atoi(match[1])
+		}
+		if m.Kind == ESPCN && m.Factor == 0 {
+			m.Factor = 2
+		}
+
+		models = append(models, m)
+	}
+
+	if len(models) == 0 {
+		return nil, fmt.Errorf("no super-resolution models found in %s", path)
+	}
+	return models, nil
+}
+
+func Factor(from, to Resolution) float64 {
+	return float64(to.H) / float64(from.H)
+}
+
+// ChooseModel picks the model for an upscale by factor and returns the
+// integer factor the network will apply. The smallest ESPCN model that
+// reaches the factor wins, so lanczos only has to shrink the remainder;
+// otherwise an SRCNN model is used, and the largest ESPCN model is the
+// last resort.
+func ChooseModel(models []Model, factor float64) (Model, int, bool) {
+	if factor <= 1 {
+		return Model{}, 0, false
+	}
+
+	var best *Model
+	for i := range models {
+		m := &models[i]
+		if m.Kind != ESPCN || float64(m.Factor) < factor {
+			continue
+		}
+		if best == nil || m.Factor < best.Factor {
+			best = m
+		}
+	}
+	if best != nil {
+		return *best, best.Factor, true
+	}
+
+	for _, m := range models {
+		if m.Kind == SRCNN {
+			return m, int(math.Ceil(factor)), true
+		}
+	}
+
+	for i := range models {
+		m := &models[i]
+		if best == nil || m.Factor > best.Factor {
+			best = m
+		}
+	}
+	return *best, best.Factor, true
+}
@@ -0,0 +1,618 @@
+package server
+
+import (
+	"context"
+	"crypto/subtle"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kiourin-studio/video-resolution/internal/encoder"
+	"kiourin-studio/video-resolution/internal/ffmpeg"
+	"kiourin-studio/video-resolution/internal/job"
+	"kiourin-studio/video-resolution/internal/logger"
+	"kiourin-studio/video-resolution/internal/queue"
+	"kiourin-studio/video-resolution/internal/store"
+	"kiourin-studio/video-resolution/vr"
+)
+
+//go:embed openapi.json
+var openAPI []byte
+
+type Request struct {
+	Input     string `json:"input"`
+	OutDir    string `json:"outdir,omitempty"`
+	ScaleMode string `json:"scale_mode,omitempty"`
+	Profile   string `json:"profile,omitempty"`
+	GPUMode   string `json:"gpu_mode,omitempty"`
+	Compress  *bool  `json:"compress,omitempty"`
+}
+
+type Job struct {
+	ID       string      `json:"id"`
+	Request  Request     `json:"request"`
+	State    store.State `json:"state"`
+	Progress float64     `json:"progress"`
+	Output   string      `json:"output,omitempty"`
+	Codec    string      `json:"codec,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Created  time.Time   `json:"created"`
+	Started  *time.Time  `json:"started,omitempty"`
+	Finished *time.Time  `json:"finished,omitempty"`
+
+	cancel context.CancelFunc
+	subs   map[chan Job]struct{}
+	rec    *store.Record
+}
+
+type Server struct {
+	Token    string
+	Pools    []queue.Pool
+	Defaults job.Options
+	Store    *store.Store
+
+	mu       sync.Mutex
+	jobs     map[string]*Job
+	queue    chan *Job                // jobs any pool may run
+	queues   map[ffmpeg.GPU]chan *Job // jobs for one pool
+	backends map[string]ffmpeg.GPU    // resolved GPU modes
+	stopping bool
+	running  sync.WaitGroup
+}
+
+func New(token string, pools []queue.Pool, defaults job.Options, st *store.Store) *Server {
+	s := &Server{
+		Token:    token,
+		Pools:    pools,
+		Defaults: defaults,
+		Store:    st,
+		jobs:     map[string]*Job{},
+		queue:    make(chan *Job, 1024),
+		queues:   map[ffmpeg.GPU]chan *Job{},
+		backends: map[string]ffmpeg.GPU{},
+	}
+	for _, p := range pools {
+		s.queues[p.GPU] = make(chan *Job, 1024)
+	}
+	return s
+}
+
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /openapi.json", s.openAPI)
+	mux.HandleFunc("POST /jobs", s.auth(s.submit))
+	mux.HandleFunc("GET /jobs", s.auth(s.list))
+	mux.HandleFunc("GET /jobs/{id}", s.auth(s.get))
+	mux.HandleFunc("POST /jobs/{id}/cancel", s.auth(s.cancelJob))
+	mux.HandleFunc("GET /jobs/{id}/events", s.auth(s.events))
+	return mux
+}
+
+// Start reloads jobs from the store, requeueing the ones that never
+// finished, and launches the workers. Each pool gets as many workers as
+// its limit. They all read from the shared queue, like a CLI batch, and
+// from their pool's own queue.
+func (s *Server) Start() {
+	s.restore()
+
+	for _, p := range s.Pools {
+		for w := 0; w < max(p.Limit, 1); w++ {
+			go func(gpu ffmpeg.GPU, own chan *Job) {
+				for {
+					select {
+					case j := <-own:
+						s.run(j, gpu)
+					case j := <-s.queue:
+						s.run(j, gpu)
+					}
+				}
+			}(p.GPU, s.queues[p.GPU])
+		}
+	}
+}
+
+// route picks the queue for a job. Jobs in the server's own GPU mode may
+// run on any pool. A job forced onto another mode waits for the pool of
+// the backend that mode resolves to, so each backend's limit holds.
+func (s *Server) route(opts job.Options) (chan *Job, error) {
+	mode := opts.GPUMode
+	if mode == "" || mode == "auto" || mode == s.Defaults.GPUMode {
+		return s.queue, nil
+	}
+	gpu := s.backend(opts)
+	q, ok := s.queues[gpu]
+	if !ok {
+		return nil, fmt.Errorf("gpu_mode %s runs on %s, which this server has no workers for", mode, gpu)
+	}
+	return q, nil
+}
+
+// backend resolves a GPU mode once and remembers the answer, unless
+// detection timed out.
+func (s *Server) backend(opts job.Options) ffmpeg.GPU {
+	s.mu.Lock()
+	gpu, ok := s.backends[opts.GPUMode]
+	s.mu.Unlock()
+	if ok {
+		return gpu
+	}
+
+	detectCtx, cancel := opts.DetectContext(context.Background())
+	defer cancel()
+	gpu = ffmpeg.ResolveGPU(detectCtx, opts.GPUMode)
+	if detectCtx.Err() == nil {
+		s.mu.Lock()
+		s.backends[opts.GPUMode] = gpu
+		s.mu.Unlock()
+	}
+	return gpu
+}
+
+// Stop cancels the running jobs and waits for them to clean up. They go
+// back to the queue in the store, so the next start picks them up again.
+func (s *Server) Stop() {
+	s.mu.Lock()
+	s.stopping = true
+	for _, j := range s.jobs {
+		if j.State == store.Running && j.cancel != nil {
+			j.cancel()
+		}
+	}
+	s.mu.Unlock()
+	s.running.Wait()
+}
+
+func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.Token == "" {
+			next(w, r)
+			return
+		}
+		token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if token == "" {
+			// EventSource cannot set headers, so SSE clients may pass it here.
+			token = r.URL.Query().Get("token")
+		}
+		if subtle.ConstantTimeCompare([]byte(token), []byte(s.Token)) != 1 {
+			writeError(w, http.StatusUnauthorized, "invalid or missing token")
+			return
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) openAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(openAPI)
+}
+
+func (s *Server) submit(w http.ResponseWriter, r *http.Request) {
+	var req Request
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid JSON: "+err.Error())
+		return
+	}
+	if err := validate(req); err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts := s.options(req)
+	q, err := s.route(opts)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	j := &Job{
+		ID:      store.NewID(),
+		Request: req,
+		State:   store.Queued,
+		Created: time.Now(),
+		subs:    map[chan Job]struct{}{},
+	}
+	j.rec = &store.Record{
+		ID:      j.ID,
+		Source:  "serve",
+		Input:   req.Input,
+		OutDir:  req.OutDir,
+		Options: opts,
+		State:   store.Queued,
+		Created: j.Created,
+	}
+	s.save(j.rec)
+
+	s.mu.Lock()
+	s.jobs[j.ID] = j
+	s.mu.Unlock()
+
+	select {
+	case q <- j:
+	default:
+		s.finish(j, store.Failed, job.Result{Err: fmt.Errorf("queue is full")})
+		writeError(w, http.StatusServiceUnavailable, "queue is full")
+		return
+	}
+
+	logger.Info("Serve", fmt.Sprintf("Job %s queued: %s", j.ID, req.Input))
+	writeJSON(w, http.StatusCreated, s.snapshot(j))
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	jobs := make([]Job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, *j)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(jobs, func(a, b int) bool { return jobs[a].Created.Before(jobs[b].Created) })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *Server) get(w http.ResponseWriter, r *http.Request) {
+	j := s.lookup(r.PathValue("id"))
+	if j == nil {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	writeJSON(w, http.StatusOK, s.snapshot(j))
+}
+
+func (s *Server) cancelJob(w http.ResponseWriter, r *http.Request) {
+	j := s.lookup(r.PathValue("id"))
+	if j == nil {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+
+	// The state is checked and changed in one go: a worker could start a
+	// queued job in between, and its encode would keep running under a
+	// cancelled label.
+	s.mu.Lock()
+	state := j.State
+	switch state {
+	case store.Queued:
+		j.State = store.Cancelled
+	case store.Running:
+		j.cancel()
+	}
+	s.mu.Unlock()
+
+	switch state {
+	case store.Queued:
+		s.finish(j, store.Cancelled, job.Result{})
+	case store.Running:
+		// run finishes the job once FFmpeg has stopped.
+	default:
+		writeError(w, http.StatusConflict, fmt.Sprintf("job is already %s", state))
+		return
+	}
+	writeJSON(w, http.StatusAccepted, s.snapshot(j))
+}
+
+// events streams the job as Server-Sent Events: one "job" event with the
+// current state on connect, then one per progress or state change, until
+// the job finishes or the client goes away.
+func (s *Server) events(w http.ResponseWriter, r *http.Request) {
+	j := s.lookup(r.PathValue("id"))
+	if j == nil {
+		writeError(w, http.StatusNotFound, "job not found")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "streaming not supported")
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+
+	ch := make(chan Job, 16)
+	s.mu.Lock()
+	current := *j
+	finished := isFinal(j.State)
+	if !finished {
+		j.subs[ch] = struct{}{}
+	}
+	s.mu.Unlock()
+
+	send := func(snap Job) {
+		data, _ := json.Marshal(snap)
+		fmt.Fprintf(w, "event: job\ndata: %s\n\n", data)
+		flusher.Flush()
+	}
+
+	send(current)
+	if finished {
+		return
+	}
+	defer func() {
+		s.mu.Lock()
+		delete(j.subs, ch)
+		s.mu.Unlock()
+	}()
+
+	for {
+		select {
+		case snap := <-ch:
+			send(snap)
+			if isFinal(snap.State) {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) run(j *Job, gpu ffmpeg.GPU) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s.mu.Lock()
+	if j.State != store.Queued || s.stopping {
+		s.mu.Unlock()
+		return
+	}
+	s.running.Add(1)
+	defer s.running.Done()
+	now := time.Now()
+	j.State = store.Running
+	j.Started = &now
+	j.cancel = cancel
+	s.mu.Unlock()
+	s.publish(j)
+
+	opts := j.rec.Options
+	if s.Store != nil {
+		stop := s.Store.Start(j.rec)
+		defer stop()
+	}
+
+	logger.Info("Serve", fmt.Sprintf("Job %s started on %s", j.ID, gpu))
+
+	lastSent := time.Time{}
+	result, _ := vr.Run(ctx, vr.Job{
+		Input:   j.Request.Input,
+		OutDir:  j.Request.OutDir,
+		Options: opts,
+		GPU:     gpu,
+		Console: true,
+		Prefix:  j.ID,
+		Planned: func(output string) {
+			j.rec.Output = output
+			s.save(j.rec)
+		},
+		Progress: func(u vr.Update) {
+			if u.Percent == 0 {
+				return
+			}
+			s.mu.Lock()
+			j.Progress = u.Percent
+			s.mu.Unlock()
+			if time.Since(lastSent) >= 500*time.Millisecond {
+				lastSent = time.Now()
+				s.publish(j)
+			}
+		},
+	})
+
+	s.mu.Lock()
+	stopping := s.stopping
+	s.mu.Unlock()
+
+	switch {
+	case ctx.Err() != nil && stopping:
+		s.requeue(j)
+		logger.Info("Serve", fmt.Sprintf("Job %s interrupted, queued for the next start", j.ID))
+		return
+	case ctx.Err() != nil:
+		s.finish(j, store.Cancelled, result)
+	case result.Err != nil:
+		s.finish(j, store.Failed, result)
+	default:
+		s.finish(j, store.Done, result)
+	}
+	logger.Info("Serve", fmt.Sprintf("Job %s %s", j.ID, s.snapshot(j).State))
+}
+
+func (s *Server) options(req Request) job.Options {
+	opts := s.Defaults
+	if req.ScaleMode != "" {
+		opts.Mode = req.ScaleMode
+	}
+	if req.Profile != "" {
+		opts.Profile = encoder.ParseProfile(req.Profile)
+	}
+	if req.GPUMode != "" {
+		opts.GPUMode = req.GPUMode
+	}
+	if req.Compress != nil {
+		opts.Compress = *req.Compress
+	}
+	return opts
+}
+
+func (s *Server) finish(j *Job, state store.State, result job.Result) {
+	s.mu.Lock()
+	now := time.Now()
+	j.State = state
+	j.Finished = &now
+	j.Output = result.Output
+	j.Codec = result.Codec
+	if state == store.Done {
+		j.Progress = 100
+	}
+	if result.Err != nil && state != store.Cancelled {
+		j.Error = result.Err.Error()
+	}
+	s.mu.Unlock()
+
+	if s.Store != nil {
+		s.Store.Finish(j.rec, state, result)
+	}
+	s.publish(j)
+}
+
+func (s *Server) requeue(j *Job) {
+	s.mu.Lock()
+	j.State = store.Queued
+	j.Progress = 0
+	j.Started = nil
+	s.mu.Unlock()
+
+	j.rec.State = store.Queued
+	j.rec.Output = ""
+	j.rec.Error = "interrupted"
+	s.save(j.rec)
+	s.publish(j)
+}
+
+func (s *Server) save(r *store.Record) {
+	if s.Store == nil {
+		return
+	}
+	if err := s.Store.Put(r); err != nil {
+		logger.Warn("Warning", fmt.Sprintf("Cannot save job %s: %v", r.ID, err))
+	}
+}
+
+// restore loads the jobs submitted to earlier runs of the server. Jobs that
+// were interrupted have already been requeued by store.Recover.
+func (s *Server) restore() {
+	if s.Store == nil {
+		return
+	}
+	records, err := s.Store.List()
+	if err != nil {
+		logger.Warn("Warning", fmt.Sprintf("Cannot load jobs: %v", err))
+		return
+	}
+
+	requeued := 0
+	for _, r := range records {
+		if r.Source != "serve" || r.State == store.Running {
+			continue
+		}
+		compress := r.Options.Compress
+		j := &Job{
+			ID: r.ID,
+			Request: Request{
+				Input:     r.Input,
+				OutDir:    r.OutDir,
+				ScaleMode: r.Options.Mode,
+				Profile:   string(r.Options.Profile),
+				GPUMode:   r.Options.GPUMode,
+				Compress:  &compress,
+			},
+			State:    r.State,
+			Output:   r.Output,
+			Error:    r.Error,
+			Created:  r.Created,
+			Started:  r.Started,
+			Finished: r.Finished,
+			subs:     map[chan Job]struct{}{},
+			rec:      r,
+		}
+		if r.State == store.Done {
+			j.Progress = 100
+		}
+		s.jobs[j.ID] = j
+
+		if r.State == store.Queued {
+			q, err := s.route(r.Options)
+			if err != nil {
+				s.finish(j, store.Failed, job.Result{Err: err})
+				continue
+			}
+			select {
+			case q <- j:
+				requeued++
+			default:
+			}
+		}
+	}
+
+	if requeued > 0 {
+		logger.Info("Serve", fmt.Sprintf("%d queued job(s) restored", requeued))
+	}
+}
+
+func (s *Server) publish(j *Job) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	snap := *j
+	for ch := range j.subs {
+		select {
+		case ch <- snap:
+		default:
+			// Slow clients miss intermediate progress, not the final state:
+			// make room for it by dropping the oldest update.
+			if isFinal(snap.State) {
+				select {
+				case <-ch:
+				default:
+				}
+				select {
+				case ch <- snap:
+				default:
+				}
+			}
+		}
+	}
+}
+
+func (s *Server) lookup(id string) *Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.jobs[id]
+}
+
+func (s *Server) snapshot(j *Job) Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return *j
+}
+
+func validate(req Request) error {
+	if req.Input == "" {
+		return fmt.Errorf("input is required")
+	}
+	switch req.ScaleMode {
+	case "", "none", "up", "down":
+	default:
+		return fmt.Errorf("scale_mode must be none, up or down")
+	}
+	switch req.Profile {
+	case "", "low", "med", "high":
+	default:
+		return fmt.Errorf("profile must be low, med or high")
+	}
+	switch req.GPUMode {
+	case "", "auto", "cpu", "nvidia", "intel", "qsv", "amd", "gpu", "igpu":
+	default:
+		return fmt.Errorf("unknown gpu_mode: %s", req.GPUMode)
+	}
+	return nil
+}
+
+func isFinal(state store.State) bool {
+	return state == store.Done || state == store.Failed || state == store.Cancelled
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
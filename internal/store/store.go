@@ -0,0 +1,237 @@
+package store
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"kiourin-studio/video-resolution/internal/chunk"
+	"kiourin-studio/video-resolution/internal/job"
+	"kiourin-studio/video-resolution/internal/output"
+)
+
+type State string
+
+const (
+	Queued    State = "queued"
+	Running   State = "running"
+	Done      State = "done"
+	Failed    State = "failed"
+	Cancelled State = "cancelled"
+)
+
+func (s State) Final() bool {
+	return s == Done || s == Failed || s == Cancelled
+}
+
+// Record is a job as kept on disk. Updated doubles as a heartbeat while the
+// job runs, which is how jobs of a crashed or rebooted process are found.
+type Record struct {
+	ID       string      `json:"id"`
+	Source   string      `json:"source"`
+	Input    string      `json:"input"`
+	OutDir   string      `json:"outdir,omitempty"`
+	Options  job.Options `json:"options"`
+	State    State       `json:"state"`
+	Output   string      `json:"output,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Attempts int         `json:"attempts"`
+	Created  time.Time   `json:"created"`
+	Updated  time.Time   `json:"updated"`
+	Started  *time.Time  `json:"started,omitempty"`
+	Finished *time.Time  `json:"finished,omitempty"`
+}
+
+const (
+	heartbeat = 15 * time.Second
+	stale     = 4 * heartbeat
+)
+
+// Store keeps one JSON file per job in a directory. Files are replaced
+// atomically, so several vr processes can share a store without locking as
+// long as each job is only written by the process running it.
+type Store struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func DefaultDir() string {
+	if dir := os.Getenv("VR_STORE"); dir != "" {
+		return dir
+	}
+	base, err := os.UserConfigDir()
+	if err != nil {
+		base = os.TempDir()
+	}
+	return filepath.Join(base, "vr", "jobs")
+}
+
+func Open(dir string) (*Store, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &Store{dir: dir}, nil
+}
+
+func NewID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+func (s *Store) Put(r *Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.put(r)
+}
+
+func (s *Store) put(r *Record) error {
+	r.Updated = time.Now()
+	data, err := json.MarshalIndent(r, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := s.path(r.ID)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (s *Store) Get(id string) (*Record, error) {
+	data, err := os.ReadFile(s.path(id))
+	if err != nil {
+		return nil, err
+	}
+	var r Record
+	if err := json.Unmarshal(data, &r); err != nil {
+		return nil, err
+	}
+	return &r, nil
+}
+
+func (s *Store) Delete(id string) error {
+	return os.Remove(s.path(id))
+}
+
+// List returns all records, oldest first.
+func (s *Store) List() ([]*Record, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var records []*Record
+	for _, e := range entries {
+		name := e.Name()
+		if e.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		r, err := s.Get(strings.TrimSuffix(name, ".json"))
+		if err != nil {
+			continue
+		}
+		records = append(records, r)
+	}
+
+	sort.Slice(records, func(a, b int) bool { return records[a].Created.Before(records[b].Created) })
+	return records, nil
+}
+
+// Start marks r as running and keeps its heartbeat fresh until the
+// returned function is called.
+func (s *Store) Start(r *Record) (stop func()) {
+	now := time.Now()
+	r.State = Running
+	r.Started = &now
+	r.Finished = nil
+	r.Error = ""
+	r.Attempts++
+	s.Put(r)
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(heartbeat)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				s.touch(r.ID)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	var once sync.Once
+	return func() { once.Do(func() { close(done) }) }
+}
+
+// touch refreshes the heartbeat from the record on disk, so it never
+// races with the runner's own updates to r.
+func (s *Store) touch(id string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r, err := s.Get(id); err == nil && r.State == Running {
+		s.put(r)
+	}
+}
+
+func (s *Store) Finish(r *Record, state State, result job.Result) error {
+	now := time.Now()
+	r.State = state
+	r.Finished = &now
+	if result.Output != "" {
+		r.Output = result.Output
+	}
+	r.Error = ""
+	if result.Err != nil && state != Cancelled {
+		r.Error = result.Err.Error()
+	}
+	return s.Put(r)
+}
+
+// Recover requeues jobs that were running in a process that is gone, and
+// removes the partial output they left behind.
+func (s *Store) Recover() ([]*Record, error) {
+	records, err := s.List()
+	if err != nil {
+		return nil, err
+	}
+
+	var recovered []*Record
+	for _, r := range records {
+		if r.State != Running || time.Since(r.Updated) < stale {
+			continue
+		}
+		if r.Output != "" {
+			tmp := output.Temp(r.Output)
+			os.Remove(tmp)
+			os.RemoveAll(chunk.Dir(tmp))
+		}
+		r.State = Queued
+		r.Output = ""
+		r.Error = "interrupted"
+		if err := s.Put(r); err != nil {
+			return recovered, err
+		}
+		recovered = append(recovered, r)
+	}
+	return recovered, nil
+}
+
+func (s *Store) path(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
@@ -0,0 +1,64 @@
+package watch
+
+import (
+	"encoding/json"
+	"os"
+)
+
+const ledgerName = ".vr-watch.json"
+
+type ledgerEntry struct {
+	State fileState
+	OK    bool
+}
+
+type ledger struct {
+	path    string
+	entries map[string]ledgerEntry
+}
+
+func openLedger(path string) (*ledger, error) {
+	l := &ledger{path: path, entries: map[string]ledgerEntry{}}
+
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &l.entries); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+func (l *ledger) get(name string) (ledgerEntry, bool) {
+	e, ok := l.entries[name]
+	return e, ok
+}
+
+func (l *ledger) set(name string, e ledgerEntry) error {
+	l.entries[name] = e
+	return l.save()
+}
+
+func (l *ledger) remove(name string) error {
+	if _, ok := l.entries[name]; !ok {
+		return nil
+	}
+	delete(l.entries, name)
+	return l.save()
+}
+
+func (l *ledger) save() error {
+	data, err := json.MarshalIndent(l.entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := l.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.path)
+}
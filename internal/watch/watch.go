@@ -0,0 +1,173 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"kiourin-studio/video-resolution/internal/logger"
+)
+
+type Config struct {
+	Dir        string
+	ArchiveDir string
+	FailedDir  string
+	Interval   time.Duration
+	Match      func(path string) bool
+	Process    func(path string) error
+}
+
+type fileState struct {
+	Size    int64
+	ModTime int64
+}
+
+// Run polls cfg.Dir until ctx ends. A file is processed once its size and
+// modification time are unchanged across two polls, then moved to the
+// archive or failed directory. Files that were processed but not yet moved
+// when vr stopped are recorded in a ledger and only moved on restart. A
+// file whose processing was cut short by ctx is left where it is.
+func Run(ctx context.Context, cfg Config) error {
+	for _, dir := range []string{cfg.ArchiveDir, cfg.FailedDir} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+	}
+
+	l, err := openLedger(filepath.Join(cfg.Dir, ledgerName))
+	if err != nil {
+		return err
+	}
+
+	pending := map[string]fileState{}
+	for {
+		entries, err := os.ReadDir(cfg.Dir)
+		if err != nil {
+			logger.Warn("Warning", fmt.Sprintf("Cannot read %s: %v", cfg.Dir, err))
+			if !sleep(ctx, cfg.Interval) {
+				return nil
+			}
+			continue
+		}
+
+		seen := map[string]bool{}
+		for _, e := range entries {
+			name := e.Name()
+			path := filepath.Join(cfg.Dir, name)
+			if e.IsDir() || strings.HasPrefix(name, ".") || !cfg.Match(path) {
+				continue
+			}
+			info, err := e.Info()
+			if err != nil {
+				continue
+			}
+			seen[name] = true
+
+			cur := fileState{Size: info.Size(), ModTime: info.ModTime().UnixNano()}
+			if prev, ok := pending[name]; !ok || prev != cur {
+				pending[name] = cur
+				continue
+			}
+			delete(pending, name)
+
+			handle(ctx, cfg, l, name, cur)
+			if ctx.Err() != nil {
+				return nil
+			}
+		}
+
+		for name := range pending {
+			if !seen[name] {
+				delete(pending, name)
+			}
+		}
+
+		if !sleep(ctx, cfg.Interval) {
+			return nil
+		}
+	}
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	select {
+	case <-ctx.Done():
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+func handle(ctx context.Context, cfg Config, l *ledger, name string, cur fileState) {
+	path := filepath.Join(cfg.Dir, name)
+
+	entry, done := l.get(name)
+	if !done || entry.State != cur {
+		logger.Info("Watch", fmt.Sprintf("New file: %s", name))
+		err := cfg.Process(path)
+		if ctx.Err() != nil {
+			return
+		}
+		entry = ledgerEntry{State: cur, OK: err == nil}
+		if err := l.set(name, entry); err != nil {
+			logger.Warn("Warning", fmt.Sprintf("Cannot update ledger: %v", err))
+		}
+	} else {
+		logger.Info("Watch", fmt.Sprintf("Already processed: %s", name))
+	}
+
+	dest := cfg.ArchiveDir
+	if !entry.OK {
+		dest = cfg.FailedDir
+	}
+	moved, err := move(path, dest)
+	if err != nil {
+		logger.Warn("Warning", fmt.Sprintf("Cannot move %s: %v", name, err))
+		return
+	}
+	logger.Info("Watch", fmt.Sprintf("Moved %s to %s", name, moved))
+
+	if err := l.remove(name); err != nil {
+		logger.Warn("Warning", fmt.Sprintf("Cannot update ledger: %v", err))
+	}
+}
+
+func move(path, dir string) (string, error) {
+	dest := filepath.Join(dir, filepath.Base(path))
+	if _, err := os.Stat(dest); err == nil {
+		ext := filepath.Ext(dest)
+		dest = fmt.Sprintf("%s-%s%s", strings.TrimSuffix(dest, ext), time.Now().Format("20060102-150405"), ext)
+	}
+
+	if err := os.Rename(path, dest); err == nil {
+		return dest, nil
+	}
+
+	// Rename fails across filesystems; fall back to copy and delete.
+	if err := copyFile(path, dest); err != nil {
+		os.Remove(dest)
+		return "", err
+	}
+	return dest, os.Remove(path)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"kiourin-studio/video-resolution/internal/logger"
+	"kiourin-studio/video-resolution/vr"
+)
+
+func openStore(dir string) *vr.Store {
+	st, err := vr.OpenStore(dir)
+	if err != nil {
+		logger.Warn("Warning", fmt.Sprintf("Job store unavailable, progress will not survive a restart: %v", err))
+		return nil
+	}
+
+	recovered, err := st.Recover()
+	if err != nil {
+		logger.Warn("Warning", fmt.Sprintf("Cannot check job store: %v", err))
+	}
+	if len(recovered) > 0 {
+		logger.Info("Queue", fmt.Sprintf("%d interrupted job(s) requeued, run \"vr jobs retry\" to finish them", len(recovered)))
+	}
+	return st
+}
+
+func runJobs(args []string) int {
+	if len(args) == 0 {
+		fmt.Println("\nError: missing jobs command (list, retry or purge)")
+		showHelp()
+		return exitUsage
+	}
+
+	command := args[0]
+	opts, err := parseArgs(args[1:])
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+		showHelp()
+		return exitUsage
+	}
+
+	closeLog, ok := setOutput(opts)
+	if !ok {
+		return exitUsage
+	}
+	defer closeLog()
+
+	st := openStore(opts.storeDir)
+	if st == nil {
+		return exitFailed
+	}
+
+	switch command {
+	case "list":
+		return listJobs(st)
+	case "retry":
+		return retryJobs(st, opts)
+	case "purge":
+		return purgeJobs(st, opts.purgeAll)
+	}
+	fmt.Printf("\nError: unknown jobs command: %s\n", command)
+	showHelp()
+	return exitUsage
+}
+
+func listJobs(st *vr.Store) int {
+	records, err := st.List()
+	if err != nil {
+		logger.Error("Error", fmt.Sprintf("Cannot read job store: %v", err))
+		return exitFailed
+	}
+	if len(records) == 0 {
+		fmt.Println("No jobs")
+		return exitOK
+	}
+
+	fmt.Printf("%-16s  %-9s  %-6s  %-16s  %s\n", "ID", "STATE", "SOURCE", "UPDATED", "INPUT")
+	for _, r := range records {
+		fmt.Printf("%-16s  %-9s  %-6s  %-16s  %s\n",
+			r.ID, r.State, r.Source, r.Updated.Format("2006-01-02 15:04"), r.Input)
+		if r.Output != "" && r.State == vr.JobDone {
+			fmt.Printf("%-16s  -> %s\n", "", r.Output)
+		}
+		if r.Error != "" {
+			fmt.Printf("%-16s  !! %s\n", "", r.Error)
+		}
+	}
+	return exitOK
+}
+
+// retryJobs runs the jobs named in opts.inputs, or every job that is not
+// done or running, with the options they were submitted with.
+func retryJobs(st *vr.Store, opts options) int {
+	records, err := st.List()
+	if err != nil {
+		logger.Error("Error", fmt.Sprintf("Cannot read job store: %v", err))
+		return exitFailed
+	}
+
+	wanted := map[string]bool{}
+	for _, id := range opts.inputs {
+		wanted[id] = true
+	}
+
+	b := vr.Batch{Store: st}
+	for _, r := range records {
+		if len(wanted) > 0 {
+			if !wanted[r.ID] {
+				continue
+			}
+			delete(wanted, r.ID)
+		}
+		if r.State == vr.JobRunning || r.State == vr.JobDone {
+			if len(opts.inputs) > 0 {
+				logger.Warn("Warning", fmt.Sprintf("Job %s is %s, skipping", r.ID, r.State))
+			}
+			continue
+		}
+
+		r.State = vr.JobQueued
+		st.Put(r)
+		b.Jobs = append(b.Jobs, vr.Job{
+			Input:   r.Input,
+			OutDir:  r.OutDir,
+			Options: r.Options,
+			Confirm: confirmOverwrite,
+			Emit:    emitFor(r.Input),
+			Console: true,
+		})
+		b.Records = append(b.Records, r)
+	}
+	for id := range wanted {
+		logger.Warn("Warning", fmt.Sprintf("No job with ID %s", id))
+	}
+
+	if len(b.Jobs) == 0 {
+		fmt.Println("Nothing to retry")
+		return exitOK
+	}
+	if !initEngine() {
+		return exitDependency
+	}
+
+	b.Pools = vr.Pools(selectGPU(opts), opts.limits())
+	ctx := interruptContext()
+	results := runBatch(ctx, b, true)
+	if ctx.Err() != nil {
+		return exitCancelled
+	}
+	return exitStatus(results)
+}
+
+func purgeJobs(st *vr.Store, all bool) int {
+	records, err := st.List()
+	if err != nil {
+		logger.Error("Error", fmt.Sprintf("Cannot read job store: %v", err))
+		return exitFailed
+	}
+
+	removed := 0
+	for _, r := range records {
+		if r.State == vr.JobRunning || (!all && !r.State.Final()) {
+			continue
+		}
+		if err := st.Delete(r.ID); err != nil && !os.IsNotExist(err) {
+			logger.Warn("Warning", fmt.Sprintf("Cannot remove job %s: %v", r.ID, err))
+			continue
+		}
+		removed++
+	}
+
+	fmt.Printf("Removed %d job(s) from %s\n", removed, st.Dir())
+	return exitOK
+}
@@ -1,19 +1,23 @@
 package main
 
 import (
-	"bufio"
+	"context"
 	"fmt"
+	"io"
+	"log/slog"
+	"maps"
 	"os"
-	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"kiourin-studio/video-resolution/internal/encoder"
-	"kiourin-studio/video-resolution/internal/ffmpeg"
+	"kiourin-studio/video-resolution/internal/events"
 	"kiourin-studio/video-resolution/internal/logger"
-	"kiourin-studio/video-resolution/internal/probe"
-	"kiourin-studio/video-resolution/internal/scaler"
+	"kiourin-studio/video-resolution/vr"
 )
 
 const Version = "1.1"
@@ -25,8 +29,12 @@ func showVersion() {
 func showHelp() {
 	fmt.Println("Video Resolution (vr) - Kiourin Studio")
 	fmt.Println("====================================")
-	fmt.Println("\nUsage: vr [OPTIONS] <input-file> [profile]")
-	fmt.Println("       vr [OPTIONS] <scale-mode> <input-file> [profile]")
+	fmt.Println("\nUsage: vr [OPTIONS] <input>... [profile]")
+	fmt.Println("       vr [OPTIONS] <scale-mode> <input>... [profile]")
+	fmt.Println("       vr watch <dir> [OPTIONS] [scale-mode] [profile]")
+	fmt.Println("       vr serve [OPTIONS] [scale-mode] [profile]")
+	fmt.Println("       vr jobs list | retry [id...] | purge [-all]")
+	fmt.Println("\nInputs can be files, directories or glob patterns.")
 	fmt.Println("\nOptions:")
 	fmt.Println("  -cpu                Force CPU encoding")
 	fmt.Println("  -nvidia, -nv        Force NVIDIA GPU encoding")
@@ -35,9 +43,49 @@ func showHelp() {
 	fmt.Println("  -gpu                Force any available GPU (auto-detect)")
 	fmt.Println("  -igpu               Force integrated GPU (Intel/AMD)")
 	fmt.Println("  -compress           Compress video (reduce bitrate)")
+	fmt.Println("  -upscale-method <m> Upscaling method: lanczos (default) or sr")
+	fmt.Println("  -sr-model <path>    SR model file (.pb) or directory of models")
+	fmt.Println("  -enhance <list>     Enhancement filters: denoise, deband, sharpen")
+	fmt.Println("  -snap               Snap the scaled size to a standard one (default)")
+	fmt.Println("  -no-snap            Keep the exact scaled size instead of a standard one")
+	fmt.Println("  -align <n>          Align output size to a multiple of 2, 4, 8 or 16")
+	fmt.Println("  -min-savings <n%>   Keep the original when the output saves less than n%")
+	fmt.Println("  -keep-original <m>  Put the kept original at the output path: copy or link")
+	fmt.Println("  -r                  Include subdirectories of input directories")
+	fmt.Println("  -outdir <dir>       Write outputs to <dir>, mirroring the input tree")
+	fmt.Println("  -o <file>           Output file (single input only)")
+	fmt.Println("  -name <template>    Output name, e.g. \"{base}-{height}p-{codec}.{ext}\"")
+	fmt.Println("  -overwrite <p>      Existing outputs: always (default), never, ask, rename")
+	fmt.Println("  -gpu-jobs <n>       Concurrent GPU encodes in a batch (NVIDIA: 2, others: 1)")
+	fmt.Println("  -cpu-jobs <n>       Concurrent CPU encodes in a batch")
+	fmt.Println("  -threads <n>        Threads per CPU encode (sizes -cpu-jobs by core count)")
+	fmt.Println("  -hybrid             Run CPU encodes next to the GPU in a batch")
+	fmt.Println("  -chunks <n>         Split each CPU encode at keyframes into <n> parts at once")
+	fmt.Println("  -dry-run            Show the plan and FFmpeg commands without encoding")
+	fmt.Println("  -plan-json          Like -dry-run, but print the plan as JSON")
+	fmt.Println("  -json               Print NDJSON events on stdout, logs on stderr")
 	fmt.Println("  -list-gpus          List available GPU encoders")
-	fmt.Println("  -v, -version        Show version information")
+	fmt.Println("  -q                  Only show warnings and errors")
+	fmt.Println("  -v, -vv             Show debug messages (-vv: also FFmpeg's own log)")
+	fmt.Println("  -log-file <path>    Append every message and FFmpeg's errors to <path>")
+	fmt.Println("  -probe-timeout <d>  Give up on ffprobe after <d> (default: 30s, 0: never)")
+	fmt.Println("  -detect-timeout <d> Give up on listing encoders after <d> (default: 15s, 0: never)")
+	fmt.Println("  -stall-timeout <d>  Stop FFmpeg when it makes no progress for <d> (default: 2m, 0: never)")
+	fmt.Println("  -version            Show version information")
 	fmt.Println("  -h, -help           Show this help message")
+	fmt.Println("\nWatch Options:")
+	fmt.Println("  -outdir <dir>       Where processed videos go (default: ../done)")
+	fmt.Println("  -archive <dir>      Where originals go after success (default: ../archive)")
+	fmt.Println("  -failed <dir>       Where originals go after failure (default: ../failed)")
+	fmt.Println("  -interval <d>       Polling interval, e.g. 5s or 1m (default: 5s)")
+	fmt.Println("\nServe Options:")
+	fmt.Println("  -listen <addr>      Address of the job API (default: 127.0.0.1:8080)")
+	fmt.Println("  -token <token>      Bearer token required by the API (or VR_TOKEN)")
+	fmt.Println("\nJob Store:")
+	fmt.Println("  -store <dir>        Where batch and server jobs are recorded (or VR_STORE)")
+	fmt.Println("  jobs list           Show recorded jobs")
+	fmt.Println("  jobs retry [id...]  Run failed, cancelled or interrupted jobs again")
+	fmt.Println("  jobs purge [-all]   Remove finished jobs (-all: also queued ones)")
 	fmt.Println("\nScale Modes (optional):")
 	fmt.Println("  -ds                 Downscale video")
 	fmt.Println("  -us                 Upscale video")
@@ -45,6 +93,18 @@ func showHelp() {
 	fmt.Println("  low                 Fast encoding, lower quality")
 	fmt.Println("  med                 Balanced encoding")
 	fmt.Println("  high                Slow encoding, highest quality")
+	fmt.Println("\nExit Codes:")
+	fmt.Println("  0                   Success (skipped files included)")
+	fmt.Println("  1                   Other failure, or a batch that failed in more than one way")
+	fmt.Println("  2                   Usage error")
+	fmt.Println("  3                   Missing dependency (ffmpeg or ffprobe)")
+	fmt.Println("  4                   Input error (file not found, no videos)")
+	fmt.Println("  5                   Probe error")
+	fmt.Println("  6                   Encoder unavailable")
+	fmt.Println("  7                   Encode failed")
+	fmt.Println("  8                   Output verification failed")
+	fmt.Println("  9                   Output error (cannot write or move the output)")
+	fmt.Println("  130                 Cancelled (Ctrl+C or SIGTERM)")
 	fmt.Println("\nExamples:")
 	fmt.Println("  vr video.mp4                     # Compress only (no scaling)")
 	fmt.Println("  vr -compress video.mp4           # Compress with auto-detect encoder")
@@ -52,361 +112,766 @@ func showHelp() {
 	fmt.Println("  vr -cpu -ds video.mp4            # Force CPU encoding")
 	fmt.Println("  vr -nvidia -us video.mp4 low     # Force NVIDIA encoding")
 	fmt.Println("  vr -intel -compress video.mp4    # Compress using Intel iGPU")
+	fmt.Println("  vr -us -upscale-method sr -sr-model models video.mp4")
+	fmt.Println("                                   # Upscale with super-resolution")
+	fmt.Println("  vr -compress -enhance denoise video.mp4")
+	fmt.Println("                                   # Denoise before compressing")
+	fmt.Println("  vr -compress -min-savings 10% -keep-original link -outdir out videos")
+	fmt.Println("                                   # Only keep encodes that save 10% or more")
 	fmt.Println("  vr -amd video.mp4                # Compress using AMD GPU")
+	fmt.Println("  vr -ds -r -outdir out videos     # Downscale a folder tree into out/")
+	fmt.Println("  vr -compress \"clips/*.mov\"       # Compress every matching file")
+	fmt.Println("  vr -ds -name \"{base}-{height}p.{ext}\" -overwrite rename video.mov")
+	fmt.Println("                                   # Name outputs by height, never replace")
+	fmt.Println("  vr watch incoming -ds high       # Process files dropped into incoming/")
+	fmt.Println("  vr serve -listen :8080 -token s3cret")
+	fmt.Println("                                   # Run the HTTP job API")
+	fmt.Println("  vr -dry-run -ds -r videos        # Show what a batch would do")
 	fmt.Println("  vr -list-gpus                    # Show available GPUs")
-	fmt.Println("  vr -v                            # Show version")
+	fmt.Println("  vr -version                      # Show version")
 	fmt.Println("  vr -h                            # Show help")
 }
 
-func parseArgs() (scaleMode, input, profileStr string, gpuMode string, compress bool, showVersionFlag, showHelpFlag, listGpusFlag bool) {
-	args := os.Args[1:]
-	gpuMode = "auto"
-	compress = false
-	showVersionFlag = false
-	showHelpFlag = false
-	listGpusFlag = false
+type options struct {
+	scaleMode     string
+	inputs        []string
+	recursive     bool
+	output        string
+	outDir        string
+	name          string
+	overwrite     vr.Policy
+	archiveDir    string
+	failedDir     string
+	interval      time.Duration
+	listen        string
+	token         string
+	storeDir      string
+	purgeAll      bool
+	profile       string
+	gpuMode       string
+	compress      bool
+	upscaleMethod string
+	srModel       string
+	enhance       []vr.Enhancement
+	align         int
+	noSnap        bool
+	gpuJobs       int
+	cpuJobs       int
+	threads       int
+	hybrid        bool
+	chunks        int
+	minSavings    float64
+	keepOriginal  string
+	dryRun        bool
+	planJSON      bool
+	json          bool
+	logLevel      slog.Level
+	logFile       string
+	probeTimeout  time.Duration
+	detectTimeout time.Duration
+	stallTimeout  time.Duration
+	showVersion   bool
+	showHelp      bool
+	listGPUs      bool
+}
+
+func parseArgs(args []string) (options, error) {
+	opts := options{
+		gpuMode:       "auto",
+		upscaleMethod: "lanczos",
+		interval:      5 * time.Second,
+		listen:        "127.0.0.1:8080",
+		storeDir:      vr.DefaultStoreDir(),
+	}
 
-	foundInput := false
 	foundScaleMode := false
 
+	value := func(i *int) (string, error) {
+		if *i+1 >= len(args) {
+			return "", fmt.Errorf("%s requires a value", args[*i])
+		}
+		*i++
+		return args[*i], nil
+	}
+
 	for i := 0; i < len(args); i++ {
 		arg := args[i]
 
 		switch arg {
 		case "-h", "-help":
-			showHelpFlag = true
-			return
-		case "-v", "-version":
-			showVersionFlag = true
-			return
+			opts.showHelp = true
+			return opts, nil
+		case "-version":
+			opts.showVersion = true
+			return opts, nil
+		case "-q":
+			opts.logLevel = slog.LevelWarn
+		case "-v":
+			opts.logLevel = slog.LevelDebug
+		case "-vv":
+			opts.logLevel = logger.Trace
+		case "-log-file":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			opts.logFile = v
 		case "-list-gpus":
-			listGpusFlag = true
-			return
+			opts.listGPUs = true
+			return opts, nil
 		case "-cpu":
-			gpuMode = "cpu"
+			opts.gpuMode = "cpu"
 		case "-nvidia", "-nv":
-			gpuMode = "nvidia"
+			opts.gpuMode = "nvidia"
 		case "-intel", "-qsv":
-			gpuMode = "intel"
+			opts.gpuMode = "intel"
 		case "-amd":
-			gpuMode = "amd"
+			opts.gpuMode = "amd"
 		case "-gpu":
-			gpuMode = "gpu"
+			opts.gpuMode = "gpu"
 		case "-igpu":
-			gpuMode = "igpu"
+			opts.gpuMode = "igpu"
 		case "-compress":
-			compress = true
+			opts.compress = true
+		case "-r":
+			opts.recursive = true
+		case "-hybrid":
+			opts.hybrid = true
+		case "-dry-run":
+			opts.dryRun = true
+		case "-plan-json":
+			opts.dryRun = true
+			opts.planJSON = true
+		case "-json":
+			opts.json = true
+		case "-all":
+			opts.purgeAll = true
+		case "-gpu-jobs", "-cpu-jobs", "-threads", "-chunks":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 {
+				return opts, fmt.Errorf("%s must be a positive number", arg)
+			}
+			switch arg {
+			case "-gpu-jobs":
+				opts.gpuJobs = n
+			case "-cpu-jobs":
+				opts.cpuJobs = n
+			case "-chunks":
+				opts.chunks = n
+			default:
+				opts.threads = n
+			}
+		case "-outdir", "-archive", "-failed":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			switch arg {
+			case "-outdir":
+				opts.outDir = v
+			case "-archive":
+				opts.archiveDir = v
+			default:
+				opts.failedDir = v
+			}
+		case "-o":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			opts.output = v
+		case "-name":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			if err := vr.ValidateName(v); err != nil {
+				return opts, err
+			}
+			opts.name = v
+		case "-overwrite":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			if opts.overwrite, err = vr.ParsePolicy(v); err != nil {
+				return opts, err
+			}
+		case "-min-savings":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			n, err := strconv.ParseFloat(strings.TrimSuffix(v, "%"), 64)
+			if err != nil || n <= 0 || n >= 100 {
+				return opts, fmt.Errorf("-min-savings must be a percentage like 10%%")
+			}
+			opts.minSavings = n
+		case "-keep-original":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			if v != "copy" && v != "link" {
+				return opts, fmt.Errorf("-keep-original must be copy or link")
+			}
+			opts.keepOriginal = v
+		case "-store":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			opts.storeDir = v
+		case "-listen", "-token":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			if arg == "-listen" {
+				opts.listen = v
+			} else {
+				opts.token = v
+			}
+		case "-probe-timeout", "-detect-timeout", "-stall-timeout":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			d, err := parseTimeout(arg, v)
+			if err != nil {
+				return opts, err
+			}
+			switch arg {
+			case "-probe-timeout":
+				opts.probeTimeout = d
+			case "-detect-timeout":
+				opts.detectTimeout = d
+			default:
+				opts.stallTimeout = d
+			}
+		case "-interval":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			if opts.interval, err = parseInterval(v); err != nil {
+				return opts, err
+			}
+		case "-snap":
+			opts.noSnap = false
+		case "-no-snap":
+			opts.noSnap = true
+		case "-upscale-method":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			if v != "lanczos" && v != "sr" {
+				return opts, fmt.Errorf("unknown upscale method: %s", v)
+			}
+			opts.upscaleMethod = v
+		case "-sr-model":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			opts.srModel = v
+		case "-enhance":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			if opts.enhance, err = vr.ParseEnhancements(v); err != nil {
+				return opts, err
+			}
+		case "-align":
+			v, err := value(&i)
+			if err != nil {
+				return opts, err
+			}
+			n, err := strconv.Atoi(v)
+			if err != nil || (n != 2 && n != 4 && n != 8 && n != 16) {
+				return opts, fmt.Errorf("-align must be 2, 4, 8 or 16")
+			}
+			opts.align = n
 		case "-ds", "-us":
 			if !foundScaleMode {
-				scaleMode = arg
+				opts.scaleMode = arg
 				foundScaleMode = true
 			}
 		default:
-			if !strings.HasPrefix(arg, "-") && !foundInput {
+			if !strings.HasPrefix(arg, "-") {
 				if arg == "low" || arg == "med" || arg == "high" {
-					if profileStr == "" {
-						profileStr = arg
+					if opts.profile == "" {
+						opts.profile = arg
 					}
 				} else {
-					input = arg
-					foundInput = true
+					opts.inputs = append(opts.inputs, arg)
 				}
 			}
 		}
 	}
 
-	return scaleMode, input, profileStr, gpuMode, compress, showVersionFlag, showHelpFlag, listGpusFlag
+	if opts.upscaleMethod == "sr" && opts.srModel == "" {
+		return opts, fmt.Errorf("-upscale-method sr requires -sr-model")
+	}
+
+	return opts, nil
+}
+
+// parseTimeout reads a timeout flag. 0 turns the limit off, which the
+// job options spell as a negative duration.
+func parseTimeout(flag, v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s: %s", flag, v)
+	}
+	if d == 0 {
+		return -1, nil
+	}
+	return d, nil
 }
 
-func listAvailableGPUs() {
+func listAvailableGPUs(opts options) {
 	fmt.Println("Available GPU Encoders:")
 	fmt.Println("=======================")
 	fmt.Printf("Video Resizer v%s\n\n", Version)
 
-	gpus := ffmpeg.GetAvailableGPUs()
+	ctx, cancel := opts.jobOptions().DetectContext(context.Background())
+	defer cancel()
+	gpus := vr.AvailableGPUs(ctx)
 	for _, gpu := range gpus {
 		fmt.Printf("  - %s\n", strings.ToUpper(string(gpu)))
 	}
 
 	fmt.Println("\nEncoders detected:")
-	cmd := exec.Command("ffmpeg", "-encoders")
-	out, _ := cmd.Output()
+	caps, err := vr.Caps(ctx)
+	if err != nil {
+		return
+	}
 
-	lines := strings.Split(string(out), "\n")
-	for _, line := range lines {
+	for _, name := range slices.Sorted(maps.Keys(caps.Encoders)) {
+		line := fmt.Sprintf("%-20s %s", name, caps.Encoders[name].Description)
 		if strings.Contains(line, "264") || strings.Contains(line, "265") {
 			if strings.Contains(line, "nvenc") ||
 				strings.Contains(line, "qsv") ||
 				strings.Contains(line, "amf") ||
 				strings.Contains(line, "vaapi") {
-				fmt.Println("  " + strings.TrimSpace(line))
+				fmt.Println("  " + line)
 			}
 		}
 	}
 }
 
 func main() {
-	scaleModeFlag, input, profileStr, gpuMode, compress, showVersionFlag, showHelpFlag, listGpusFlag := parseArgs()
-	if showVersionFlag {
+	os.Exit(run(os.Args[1:]))
+}
+
+func run(args []string) int {
+	if len(args) > 0 {
+		switch args[0] {
+		case "watch":
+			return runWatch(args[1:])
+		case "serve":
+			return runServe(args[1:])
+		case "jobs":
+			return runJobs(args[1:])
+		}
+	}
+
+	opts, err := parseArgs(args)
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+		showHelp()
+		return exitUsage
+	}
+	if opts.showVersion {
 		showVersion()
-		return
+		return exitOK
 	}
-	if showHelpFlag {
+	if opts.showHelp {
 		showHelp()
-		return
+		return exitOK
 	}
-	if listGpusFlag {
-		if err := ffmpeg.Init(); err != nil {
+	if opts.listGPUs {
+		if err := vr.Init(); err != nil {
 			fmt.Println("Error: Failed to find FFmpeg in PATH. Please install FFmpeg first.")
-			return
+			return exitDependency
 		}
-		listAvailableGPUs()
-		return
+		listAvailableGPUs(opts)
+		return exitOK
 	}
 
-	if input == "" {
-		if len(os.Args) == 1 {
-			showHelp()
-		} else {
+	if len(opts.inputs) == 0 {
+		if len(args) > 0 {
 			fmt.Println("\nError: Missing input file")
-			showHelp()
 		}
-		return
+		showHelp()
+		return exitUsage
 	}
 
-	logger.Info("Init", "Preparing engine...")
-	time.Sleep(300 * time.Millisecond)
-
-	if err := ffmpeg.Init(); err != nil {
-		logger.Info("Error", "Failed to find FFmpeg in PATH. Please install FFmpeg first.")
-		return
+	closeLog, ok := setOutput(opts)
+	if !ok {
+		return exitUsage
 	}
-	logger.Info("Init", "FFmpeg ready")
+	defer closeLog()
 
-	if _, err := os.Stat(input); os.IsNotExist(err) {
-		logger.Info("Error", fmt.Sprintf("File not found: %s", input))
-		return
+	if !initEngine() {
+		return exitDependency
 	}
 
-	profile := encoder.Med
-	if profileStr != "" {
-		profile = encoder.ParseProfile(profileStr)
+	items, err := vr.Expand(opts.inputs, opts.recursive)
+	if err != nil {
+		logger.Error("Error", fmt.Sprintf("Cannot read inputs: %v", err))
+		return exitInput
+	}
+	if len(items) == 0 {
+		logger.Error("Error", "No video files found")
+		return exitInput
 	}
 
-	var detectedGPU ffmpeg.GPU
-	if gpuMode != "auto" {
-		logger.Info("Mode", fmt.Sprintf("Forcing %s encoding...", gpuMode))
-		detectedGPU = ffmpeg.SetForcedGPU(gpuMode)
-
-		if detectedGPU == ffmpeg.CPU && gpuMode != "cpu" {
-			logger.Info("Warning",
-				fmt.Sprintf("%s encoder not available, falling back to CPU", gpuMode))
+	detectedGPU := selectGPU(opts)
+	jobOpts := opts.jobOptions()
 
-			available := ffmpeg.GetAvailableGPUs()
-			if len(available) > 1 {
-				logger.Info("Info", "Available encoders:")
-				for _, gpu := range available {
-					logger.Info("      ", string(gpu))
-				}
-			}
+	isBatch := len(items) > 1
+	for _, in := range opts.inputs {
+		if info, err := os.Stat(in); (err == nil && info.IsDir()) || vr.IsPattern(in) {
+			isBatch = true
 		}
-	} else {
-		logger.Info("Mode", "Auto-detecting best encoder...")
-		detectedGPU = ffmpeg.DetectGPU()
 	}
-
-	logger.Info("Scan", "Reading video info...")
-	res, err := probe.ResolutionOf(input)
-	if err != nil {
-		logger.Info("Error", "Cannot read video")
-		return
+	if isBatch && opts.output != "" {
+		logger.Error("Error", "-o needs a single input file, use -outdir and -name for batches")
+		return exitUsage
 	}
-	dur, _ := probe.Duration(input)
 
-	logger.Info("Scan", fmt.Sprintf("Resolution: %dx%d", res.W, res.H))
-	if dur > 0 {
-		logger.Info("Scan", fmt.Sprintf("Duration: %.0f sec", dur))
+	if opts.dryRun {
+		return runPlans(items, opts, detectedGPU, jobOpts)
 	}
 
-	mode := "none"
-	if scaleModeFlag != "" {
-		mode = map[string]string{"-ds": "down", "-us": "up"}[scaleModeFlag]
+	b := vr.Batch{Pools: vr.Pools(detectedGPU, opts.limits())}
+	if isBatch {
+		b.Store = openStore(opts.storeDir)
 	}
-
-	var target scaler.Resolution
-	if mode != "none" {
-		target = scaler.Auto(
-			scaler.Resolution{W: res.W, H: res.H},
-			mode,
-		)
-	} else {
-		target = scaler.Resolution{W: res.W, H: res.H}
-		logger.Info("Plan", "Mode: No scaling (compress only)")
+	for _, item := range items {
+		j := newJob(item, opts)
+		j.Options = jobOpts
+		b.Jobs = append(b.Jobs, j)
+
+		if b.Store != nil {
+			rec := &vr.Record{
+				ID:      vr.NewJobID(),
+				Source:  "batch",
+				Input:   j.Input,
+				OutDir:  j.OutDir,
+				Options: jobOpts,
+				State:   vr.JobQueued,
+				Created: time.Now(),
+			}
+			b.Store.Put(rec)
+			b.Records = append(b.Records, rec)
+		}
 	}
 
-	gpuName := string(detectedGPU)
-	if detectedGPU == ffmpeg.CPU {
-		gpuName = "CPU (software)"
-	}
-	logger.Info("GPU ", strings.ToUpper(gpuName)+" detected")
+	ctx := interruptContext()
+	results := runBatch(ctx, b, isBatch)
 
-	if mode != "none" {
-		logger.Info("Plan", "Mode: "+map[string]string{"up": "Upscale", "down": "Downscale"}[mode])
-	}
-	logger.Info("Plan", "Profile: "+string(profile))
-	if compress {
-		logger.Info("Plan", "Compression: ON")
+	if ctx.Err() != nil {
+		return exitCancelled
 	}
-	logger.Info("Plan", fmt.Sprintf("Target: %dx%d", target.W, target.H))
+	return exitStatus(results)
+}
 
-	enc := encoder.Auto(profile)
+// forceTimeout bounds the wait for jobs to return after a forced stop.
+const forceTimeout = 15 * time.Second
+
+// interruptContext is cancelled by the first SIGINT or SIGTERM, so running
+// encodes can stop cleanly and remove their partial output. A second
+// signal kills FFmpeg at once; the jobs still clean up as they return.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	sigs := make(chan os.Signal, 2)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+
+	go func() {
+		<-sigs
+		logger.Restore()
+		logger.Warn("Stop", "Stopping, press Ctrl+C again to force")
+		cancel()
+
+		<-sigs
+		logger.Restore()
+		logger.Warn("Stop", "Forcing stop")
+		vr.ForceStop()
+		// run returns, and the process ends, once the killed jobs have
+		// removed their .partial files and chunk directories. os.Exit
+		// skips that cleanup, so it is only for jobs that never return.
+		time.Sleep(forceTimeout)
+		os.Exit(exitCancelled)
+	}()
+	return ctx
+}
 
-	if compress {
-		enc = encoder.ApplyCompression(enc, detectedGPU, profile)
-	}
-
-	baseName := input
-	extensions := []string{".mp4", ".mov", ".avi", ".mkv", ".webm", ".flv", ".wmv"}
-	for _, ext := range extensions {
-		if strings.HasSuffix(strings.ToLower(input), ext) {
-			baseName = strings.TrimSuffix(input, ext)
-			break
-		}
+func newJob(item vr.Item, opts options) vr.Job {
+	j := vr.Job{Input: item.Path, Output: opts.output, Confirm: confirmOverwrite, Emit: emitFor(item.Path), Console: true}
+	if opts.outDir != "" {
+		j.OutDir = filepath.Join(opts.outDir, filepath.Dir(item.Rel))
 	}
+	return j
+}
 
-	suffixes := []string{}
+// console gets the human-readable output. With -json or -plan-json it
+// moves to stderr so stdout carries only JSON.
+var (
+	console io.Writer = os.Stdout
+	ev      *events.Writer
+)
 
-	if mode != "none" {
-		suffixes = append(suffixes, fmt.Sprintf("%dx%d", target.W, target.H))
+// setOutput applies the logging flags. The returned function closes the
+// log file, if there is one.
+func setOutput(opts options) (func(), bool) {
+	logger.SetLevel(opts.logLevel)
+	if opts.json || opts.planJSON {
+		console = os.Stderr
+		logger.SetOutput(os.Stderr)
 	}
-	if compress {
-		suffixes = append(suffixes, "compressed")
+	if opts.json {
+		ev = events.New(os.Stdout)
 	}
 
-	output := baseName
-	if len(suffixes) > 0 {
-		output += "-" + strings.Join(suffixes, "-")
+	if opts.logFile == "" {
+		return func() {}, true
+	}
+	closeFile, err := logger.SetLogFile(opts.logFile)
+	if err != nil {
+		logger.Error("Error", fmt.Sprintf("Cannot open log file: %v", err))
+		return nil, false
 	}
-	output += ".mp4"
+	return func() { closeFile() }, true
+}
 
-	if _, err := os.Stat(output); err == nil {
-		logger.Info("Warning", fmt.Sprintf("Output file already exists: %s", output))
-		logger.Info("Info", "It will be overwritten automatically")
+func emitFor(input string) func(event string, data any) {
+	if ev == nil {
+		return nil
 	}
+	return func(event string, data any) {
+		ev.Emit(event, input, data)
+	}
+}
 
-	logger.Info("Run ", "Encoding started...")
+func initEngine() bool {
+	logger.Info("Init", "Preparing engine...")
+	time.Sleep(300 * time.Millisecond)
 
-	argsEnc := []string{
-		"-y",
-		"-i", input,
+	if err := vr.Init(); err != nil {
+		logger.Error("Error", "Failed to find FFmpeg in PATH. Please install FFmpeg first.")
+		ev.Emit("error", "", events.Message{Message: "ffmpeg not found", Code: string(vr.MissingDependency)})
+		return false
 	}
+	logger.Info("Init", "FFmpeg ready")
+	return true
+}
 
-	if mode != "none" {
-		argsEnc = append(argsEnc, "-vf", fmt.Sprintf("scale=%d:%d:flags=lanczos", target.W, target.H))
-	}
+func selectGPU(opts options) vr.GPU {
+	gpuMode := opts.gpuMode
+	ctx, cancel := opts.jobOptions().DetectContext(context.Background())
+	defer cancel()
+	defer func() {
+		if ctx.Err() == context.DeadlineExceeded {
+			logger.Warn("Warning", "FFmpeg did not list its encoders in time, check -detect-timeout")
+		}
+	}()
 
-	argsEnc = append(argsEnc, "-c:v", enc.Codec)
-	argsEnc = append(argsEnc, enc.Params...)
-	argsEnc = append(argsEnc,
-		"-pix_fmt", "yuv420p",
-		"-c:a", "copy",
-		"-movflags", "+faststart",
-		"-progress", "pipe:1",
-		"-nostats",
-		"-loglevel", "error",
-		output,
-	)
+	if gpuMode == "auto" {
+		logger.Info("Mode", "Auto-detecting best encoder...")
+		return vr.DetectGPU(ctx)
+	}
 
-	logger.Info("Debug", fmt.Sprintf("Codec: %s", enc.Codec))
-	logger.Info("Debug", fmt.Sprintf("Params: %v", enc.Params))
+	logger.Info("Mode", fmt.Sprintf("Forcing %s encoding...", gpuMode))
+	detectedGPU := vr.ResolveGPU(ctx, gpuMode)
 
-	cmd := exec.Command("ffmpeg", argsEnc...)
-	stdout, _ := cmd.StdoutPipe()
-	cmd.Stderr = os.Stderr
+	if detectedGPU == vr.CPU && gpuMode != "cpu" {
+		logger.Warn("Warning",
+			fmt.Sprintf("%s encoder not available, falling back to CPU", gpuMode))
+		ev.Emit("fallback", "", map[string]string{"from": gpuMode, "to": "cpu", "reason": "encoder not available"})
 
-	if err := cmd.Start(); err != nil {
-		logger.Info("Error", fmt.Sprintf("Failed to start FFmpeg: %v", err))
+		available := vr.AvailableGPUs(ctx)
+		if len(available) > 1 {
+			logger.Info("Info", "Available encoders:")
+			for _, gpu := range available {
+				logger.Info("      ", string(gpu))
+			}
+		}
+	}
+	return detectedGPU
+}
 
-		if detectedGPU != ffmpeg.CPU {
-			logger.Info("Warning", "GPU encoding failed, trying CPU fallback...")
-			ffmpeg.SetForcedGPU("cpu")
-			enc = encoder.Auto(profile)
+// jobOptions turns the flags into job options. Settings no flag was given
+// for stay empty and take vr's defaults when the job runs.
+func (o options) jobOptions() vr.Options {
+	var profile vr.Profile
+	if o.profile != "" {
+		profile = vr.ParseProfile(o.profile)
+	}
 
-			if compress {
-				enc = encoder.ApplyCompression(enc, ffmpeg.CPU, profile)
-			}
+	return vr.Options{
+		Mode:          map[string]string{"-ds": vr.Down, "-us": vr.Up}[o.scaleMode],
+		Profile:       profile,
+		GPUMode:       o.gpuMode,
+		Compress:      o.compress,
+		UpscaleMethod: o.upscaleMethod,
+		SRModel:       o.srModel,
+		Enhance:       o.enhance,
+		Align:         o.align,
+		NoSnap:        o.noSnap,
+		Threads:       o.threads,
+		Chunks:        o.chunks,
+		Name:          o.name,
+		Overwrite:     o.overwrite,
+		MinSavings:    o.minSavings,
+		KeepOriginal:  o.keepOriginal,
+		ProbeTimeout:  o.probeTimeout,
+		DetectTimeout: o.detectTimeout,
+		StallTimeout:  o.stallTimeout,
+	}
+}
 
-			argsEnc = []string{
-				"-y", "-i", input,
-			}
-			if mode != "none" {
-				argsEnc = append(argsEnc, "-vf", fmt.Sprintf("scale=%d:%d:flags=lanczos", target.W, target.H))
-			}
-			argsEnc = append(argsEnc, "-c:v", enc.Codec)
-			argsEnc = append(argsEnc, enc.Params...)
-			argsEnc = append(argsEnc,
-				"-pix_fmt", "yuv420p",
-				"-c:a", "copy",
-				"-movflags", "+faststart",
-				"-progress", "pipe:1",
-				"-nostats",
-				"-loglevel", "error",
-				output,
-			)
+// confirmOverwrite asks before replacing an existing output. Without a
+// terminal to ask on, the file is left alone.
+func confirmOverwrite(path string) bool {
+	info, err := os.Stdin.Stat()
+	if err != nil || info.Mode()&os.ModeCharDevice == 0 {
+		return false
+	}
+	return logger.Ask(fmt.Sprintf("%s already exists. Overwrite?", path))
+}
 
-			cmd = exec.Command("ffmpeg", argsEnc...)
-			stdout, _ = cmd.StdoutPipe()
-			cmd.Stderr = os.Stderr
+// limits sizes the worker pools from the -gpu-jobs, -cpu-jobs, -threads
+// and -hybrid flags.
+func (o options) limits() vr.Limits {
+	return vr.Limits{GPUJobs: o.gpuJobs, CPUJobs: o.cpuJobs, Threads: o.threads, Hybrid: o.hybrid}
+}
 
-			if err := cmd.Start(); err != nil {
-				logger.Info("Error", fmt.Sprintf("CPU fallback also failed: %v", err))
-				return
-			}
-			logger.Info("Info", "Using CPU encoder as fallback")
-		} else {
-			return
+// runBatch runs b on the console. Jobs running side by side get their
+// file name as a prefix; batches end with a summary.
+func runBatch(ctx context.Context, b vr.Batch, isBatch bool) []vr.Result {
+	concurrent := b.Concurrent()
+	if concurrent {
+		for _, p := range b.Pools {
+			logger.Info("Queue", fmt.Sprintf("%s: %d concurrent job(s)", strings.ToUpper(string(p.GPU)), p.Limit))
+		}
+		for i := range b.Jobs {
+			b.Jobs[i].Prefix = filepath.Base(b.Jobs[i].Input)
 		}
 	}
 
-	scanner := bufio.NewScanner(stdout)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "out_time_ms=") {
-			ms, err := strconv.ParseFloat(strings.TrimPrefix(line, "out_time_ms="), 64)
-			if err == nil && dur > 0 {
-				p := (ms / 1_000_000) / dur * 100
-				if p > 100 {
-					p = 100
-				}
-				logger.Inline(fmt.Sprintf("Progress: %.1f%%", p))
-			}
+	n := len(b.Jobs)
+	b.Started = func(i int, gpu vr.GPU) {
+		input := b.Jobs[i].Input
+		if concurrent {
+			log := &logger.Logger{Prefix: b.Jobs[i].Prefix, Shared: true}
+			log.Info("File", fmt.Sprintf("(%d/%d) %s on %s", i+1, n, input, gpu))
+		} else if isBatch {
+			fmt.Fprintln(console)
+			logger.Info("File", fmt.Sprintf("(%d/%d) %s", i+1, n, input))
 		}
 	}
 
-	if err := cmd.Wait(); err != nil {
-		logger.Info("Error", fmt.Sprintf("Encoding failed: %v", err))
-		fmt.Println()
-		return
+	started := time.Now()
+	results := vr.RunBatch(ctx, b)
+	if isBatch {
+		printSummary(results, time.Since(started))
 	}
+	return results
+}
+
+func printSummary(results []vr.Result, elapsed time.Duration) {
+	fmt.Fprintln(console)
+	fmt.Fprintln(console, "Summary")
+	fmt.Fprintln(console, "=======")
 
-	fmt.Println()
-	logger.Info("Done", fmt.Sprintf("Saved as %s", output))
+	var ok, failed, skipped, kept int
+	var inSize, outSize int64
 
-	operation := "Compressed"
-	if mode != "none" {
-		operation = map[string]string{"up": "Upscaled", "down": "Downscaled"}[mode]
-		if compress {
-			operation += " and compressed"
+	for _, r := range results {
+		if r.Err != nil {
+			failed++
+			fmt.Fprintf(console, "  FAIL  %s: %v\n", r.Input, r.Err)
+			printAttempts(r.Attempts)
+			continue
 		}
+		if r.Skipped {
+			skipped++
+			fmt.Fprintf(console, "  SKIP  %s: %s already exists\n", r.Input, r.Output)
+			continue
+		}
+		if r.NotWorth {
+			kept++
+			fmt.Fprintf(console, "  KEEP  %s: not worth it (saves %.1f%%)\n", r.Input, r.Savings)
+			continue
+		}
+		ok++
+		inSize += r.InSize
+		outSize += r.OutSize
+		fmt.Fprintf(console, "  OK    %s -> %s (%s, %s)\n", r.Input, r.Output,
+			formatSize(r.OutSize), r.Elapsed.Round(time.Second))
+		printAttempts(r.Attempts)
+	}
+
+	fmt.Fprintf(console, "\nFiles: %d total, %d succeeded, %d failed", len(results), ok, failed)
+	if skipped > 0 {
+		fmt.Fprintf(console, ", %d skipped", skipped)
 	}
+	if kept > 0 {
+		fmt.Fprintf(console, ", %d not worth it", kept)
+	}
+	fmt.Fprintln(console)
+	if ok > 0 {
+		fmt.Fprintf(console, "Size:  %s → %s\n", formatSize(inSize), formatSize(outSize))
+	}
+	fmt.Fprintf(console, "Time:  %s\n", elapsed.Round(time.Second))
+
+	ev.Emit("summary", "", summaryEvent{
+		Total:     len(results),
+		Succeeded: ok,
+		Failed:    failed,
+		Skipped:   skipped,
+		NotWorth:  kept,
+		InSize:    inSize,
+		OutSize:   outSize,
+		Elapsed:   elapsed.Seconds(),
+	})
+}
 
-	logger.Info("Info", fmt.Sprintf("Operation: %s", operation))
-	logger.Info("Info", fmt.Sprintf("Original: %dx%d → Target: %dx%d",
-		res.W, res.H, target.W, target.H))
-	logger.Info("Info", fmt.Sprintf("Encoder: %s", enc.Codec))
-	if compress {
-		logger.Info("Info", "Compression: Applied")
+func printAttempts(attempts []vr.Attempt) {
+	for _, a := range attempts {
+		fmt.Fprintf(console, "        %s failed (%s): %s\n", a.Codec, a.Failure, a.Reason)
 	}
+}
 
-	ffmpeg.ResetForcedGPU()
+type summaryEvent struct {
+	Total     int     `json:"total"`
+	Succeeded int     `json:"succeeded"`
+	Failed    int     `json:"failed"`
+	Skipped   int     `json:"skipped"`
+	NotWorth  int     `json:"not_worth_it"`
+	InSize    int64   `json:"in_size"`
+	OutSize   int64   `json:"out_size"`
+	Elapsed   float64 `json:"elapsed"`
+}
+
+func formatSize(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
 }
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"kiourin-studio/video-resolution/vr"
+)
+
+// runPlans probes and plans every item without encoding, then prints the
+// plans for people or, with -plan-json, as JSON.
+func runPlans(items []vr.Item, opts options, gpu vr.GPU, jobOpts vr.Options) int {
+	plans := make([]vr.Plan, len(items))
+	results := make([]vr.Result, len(items))
+	for i, item := range items {
+		j := newJob(item, opts)
+		j.Options = jobOpts
+		j.GPU = gpu
+		j.DryRun = true
+
+		r, _ := vr.Run(context.Background(), j)
+		results[i] = r
+		switch {
+		case r.Plan != nil:
+			plans[i] = *r.Plan
+		case r.Err != nil:
+			plans[i] = vr.Plan{Input: r.Input, Output: r.Output, Error: r.Err.Error()}
+		default:
+			plans[i] = vr.Plan{Input: r.Input, Output: r.Output, Exists: true, Skipped: r.Skipped, Source: r.Source, Target: r.Target}
+		}
+	}
+
+	if opts.planJSON {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		enc.Encode(plans)
+		return exitStatus(results)
+	}
+
+	for _, p := range plans {
+		printPlan(p, opts.overwrite)
+	}
+	return exitStatus(results)
+}
+
+func printPlan(p vr.Plan, policy vr.Policy) {
+	fmt.Fprintln(console)
+	fmt.Fprintf(console, "Plan: %s\n", p.Input)
+	if p.Error != "" {
+		fmt.Fprintf(console, "  Error:    %s\n", p.Error)
+		return
+	}
+	if p.Skipped {
+		fmt.Fprintf(console, "  Skipped:  %s already exists\n", p.Output)
+		return
+	}
+
+	fmt.Fprintf(console, "  Encoder:  %s (%s, %s pipeline)\n", p.Codec, strings.ToUpper(p.GPU), p.Pipeline)
+	fmt.Fprintf(console, "  Size:     %dx%d → %dx%d\n", p.Source.W, p.Source.H, p.Target.W, p.Target.H)
+	if p.Filters != "" {
+		fmt.Fprintf(console, "  Filters:  %s\n", p.Filters)
+	}
+	output := p.Output
+	if p.Exists {
+		if policy == vr.Ask {
+			output += " (exists, will ask before replacing)"
+		} else {
+			output += " (exists, will be replaced)"
+		}
+	}
+	fmt.Fprintf(console, "  Output:   %s\n", output)
+	for _, args := range p.Commands {
+		fmt.Fprintf(console, "  Command:  %s\n", commandLine(args))
+	}
+}
+
+func commandLine(args []string) string {
+	parts := []string{"ffmpeg"}
+	for _, a := range args {
+		parts = append(parts, shellQuote(a))
+	}
+	return strings.Join(parts, " ")
+}
+
+func shellQuote(s string) string {
+	if s != "" && !strings.ContainsAny(s, " \t\n'\"\\$`!*?[]{}()<>|&;#~") {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
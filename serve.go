@@ -0,0 +1,89 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"kiourin-studio/video-resolution/internal/logger"
+	"kiourin-studio/video-resolution/internal/server"
+	"kiourin-studio/video-resolution/vr"
+)
+
+func runServe(args []string) int {
+	opts, err := parseArgs(args)
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+		showHelp()
+		return exitUsage
+	}
+
+	if opts.output != "" {
+		fmt.Println("\nError: -o cannot be used with serve, use -outdir and -name")
+		showHelp()
+		return exitUsage
+	}
+	token := opts.token
+	if token == "" {
+		token = os.Getenv("VR_TOKEN")
+	}
+	if token == "" && !isLoopback(opts.listen) {
+		fmt.Println("\nError: serve needs -token (or VR_TOKEN) when listening beyond localhost")
+		return exitUsage
+	}
+
+	closeLog, ok := setOutput(opts)
+	if !ok {
+		return exitUsage
+	}
+	defer closeLog()
+
+	if !initEngine() {
+		return exitDependency
+	}
+	detectedGPU := selectGPU(opts)
+
+	srv := server.New(token, vr.Pools(detectedGPU, opts.limits()), opts.jobOptions(), openStore(opts.storeDir))
+	srv.Start()
+
+	logger.Info("Serve", fmt.Sprintf("Listening on http://%s", opts.listen))
+	if token == "" {
+		logger.Warn("Warning", "No token set, the API is open to local users")
+	}
+
+	ctx := interruptContext()
+	hs := &http.Server{Addr: opts.listen, Handler: srv.Handler()}
+	go func() {
+		<-ctx.Done()
+		// Event streams never end on their own, so give requests a moment
+		// and then close what is left.
+		shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		if hs.Shutdown(shutdown) != nil {
+			hs.Close()
+		}
+	}()
+
+	if err := hs.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logger.Error("Error", fmt.Sprintf("Server stopped: %v", err))
+		return exitFailed
+	}
+	srv.Stop()
+	logger.Info("Serve", "Stopped")
+	return exitCancelled
+}
+
+func isLoopback(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
@@ -0,0 +1,156 @@
+package vr
+
+import (
+	"context"
+
+	"kiourin-studio/video-resolution/internal/batch"
+	"kiourin-studio/video-resolution/internal/job"
+	"kiourin-studio/video-resolution/internal/queue"
+	"kiourin-studio/video-resolution/internal/store"
+)
+
+type (
+	// Item is an input found by Expand. Rel is its path below the
+	// directory it was found in, for mirroring the tree into an OutDir.
+	Item = batch.Item
+	// Pool is a group of workers bound to one backend. Limit is the
+	// number of jobs it runs at the same time.
+	Pool = queue.Pool
+)
+
+// Expand turns files, directories and glob patterns into the videos they
+// name, descending into subdirectories when recursive is set. Inputs that
+// do not exist are kept, so they fail when run instead of vanishing.
+func Expand(inputs []string, recursive bool) ([]Item, error) {
+	return batch.Expand(inputs, recursive, job.IsVideo)
+}
+
+// IsPattern reports whether input is a glob pattern.
+func IsPattern(input string) bool {
+	return batch.IsPattern(input)
+}
+
+// IsVideo reports whether path has a video file extension.
+func IsVideo(path string) bool {
+	return job.IsVideo(path)
+}
+
+// Limits size the worker pools of a batch. A zero GPUJobs or CPUJobs
+// takes the backend's default; Threads is the thread count of each CPU
+// encode, which sizes the default CPU pool. Hybrid runs CPU encodes next
+// to the GPU.
+type Limits struct {
+	GPUJobs int
+	CPUJobs int
+	Threads int
+	Hybrid  bool
+}
+
+// Pools decides which backends take part in a batch on gpu and how many
+// jobs each runs at once.
+func Pools(gpu GPU, l Limits) []Pool {
+	limit := func(g GPU) int {
+		if g == CPU {
+			if l.CPUJobs > 0 {
+				return l.CPUJobs
+			}
+		} else if l.GPUJobs > 0 {
+			return l.GPUJobs
+		}
+		return queue.DefaultLimit(g, l.Threads)
+	}
+
+	pools := []Pool{{GPU: gpu, Limit: limit(gpu)}}
+	if l.Hybrid && gpu != CPU {
+		pools = append(pools, Pool{GPU: CPU, Limit: limit(CPU)})
+	}
+	return pools
+}
+
+// Batch is a set of jobs run on worker pools. Each job runs on its pool's
+// backend; Job.GPU is set by RunBatch.
+type Batch struct {
+	Jobs  []Job
+	Pools []Pool
+
+	// Store, when set, keeps Records[i] up to date while Jobs[i] runs.
+	// Jobs without a record are not recorded.
+	Store   *Store
+	Records []*Record
+
+	// Started is called as each job starts, with the backend it runs on.
+	// Calls come from the workers, several at a time.
+	Started func(i int, gpu GPU)
+}
+
+// Concurrent reports whether the batch runs more than one job at a time.
+func (b Batch) Concurrent() bool {
+	return len(b.Jobs) > 1 && (len(b.Pools) > 1 || (len(b.Pools) == 1 && b.Pools[0].Limit > 1))
+}
+
+// RunBatch runs b's jobs and returns their results in order. Jobs that
+// have not started when ctx ends are reported as Cancelled and stay
+// queued in the store.
+func RunBatch(ctx context.Context, b Batch) []Result {
+	// Jobs retried from the store keep the GPU mode they were started
+	// with. Each mode is resolved once per batch, not per job.
+	forced := map[string]GPU{}
+	for _, j := range b.Jobs {
+		mode := j.Options.GPUMode
+		if _, ok := forced[mode]; ok || mode == "" || mode == "auto" {
+			continue
+		}
+		detectCtx, cancel := j.Options.DetectContext(ctx)
+		forced[mode] = ResolveGPU(detectCtx, mode)
+		cancel()
+	}
+
+	results := make([]Result, len(b.Jobs))
+	queue.Run(b.Pools, len(b.Jobs), func(i int, gpu GPU) {
+		j := b.Jobs[i]
+		if ctx.Err() != nil {
+			results[i] = Result{Input: j.Input, Err: ctx.Err(), Code: Cancelled}
+			return
+		}
+
+		// The CPU pool stays on the CPU, or a forced GPU mode would start
+		// more GPU sessions than the GPU pool allows.
+		j.GPU = gpu
+		if g, ok := forced[j.Options.GPUMode]; ok && gpu != CPU {
+			j.GPU = g
+		}
+		if b.Started != nil {
+			b.Started(i, j.GPU)
+		}
+
+		var rec *Record
+		if b.Store != nil && i < len(b.Records) {
+			rec = b.Records[i]
+		}
+		if rec == nil {
+			results[i], _ = Run(ctx, j)
+			return
+		}
+
+		stop := b.Store.Start(rec)
+		planned := j.Planned
+		j.Planned = func(output string) {
+			rec.Output = output
+			b.Store.Put(rec)
+			if planned != nil {
+				planned(output)
+			}
+		}
+		results[i], _ = Run(ctx, j)
+		stop()
+
+		state := store.Done
+		if results[i].Code == Cancelled {
+			state = store.Cancelled
+		} else if results[i].Err != nil {
+			state = store.Failed
+		}
+		b.Store.Finish(rec, state, results[i])
+	})
+	return results
+}
@@ -0,0 +1,37 @@
+package vr
+
+import "kiourin-studio/video-resolution/internal/store"
+
+type (
+	// Store records jobs as JSON files in a directory, so they can be
+	// listed and retried after the process is gone.
+	Store = store.Store
+	// Record is a job as the store keeps it.
+	Record = store.Record
+	// State is where a recorded job is: queued, running, done, failed or
+	// cancelled.
+	State = store.State
+)
+
+const (
+	JobQueued    = store.Queued
+	JobRunning   = store.Running
+	JobDone      = store.Done
+	JobFailed    = store.Failed
+	JobCancelled = store.Cancelled
+)
+
+// OpenStore opens the store in dir, creating the directory if needed.
+func OpenStore(dir string) (*Store, error) {
+	return store.Open(dir)
+}
+
+// DefaultStoreDir is VR_STORE, or vr/jobs in the user's config directory.
+func DefaultStoreDir() string {
+	return store.DefaultDir()
+}
+
+// NewJobID returns a new random ID for a Record.
+func NewJobID() string {
+	return store.NewID()
+}
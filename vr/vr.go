@@ -0,0 +1,270 @@
+// Package vr runs vr's encode pipeline from Go programs: probe a video,
+// pick a size and an encoder, encode, and get the result. It keeps no
+// global state, so any number of jobs can run at once in one process.
+//
+//	res, err := vr.Run(ctx, vr.Job{
+//		Input:    "in.mov",
+//		OutDir:   "out",
+//		Options:  vr.Options{Mode: vr.Down, Profile: vr.High},
+//		Progress: func(u vr.Update) { fmt.Printf("%.0f%%\n", u.Percent) },
+//	})
+package vr
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"kiourin-studio/video-resolution/internal/encoder"
+	"kiourin-studio/video-resolution/internal/ffmpeg"
+	"kiourin-studio/video-resolution/internal/job"
+	"kiourin-studio/video-resolution/internal/logger"
+	"kiourin-studio/video-resolution/internal/output"
+	"kiourin-studio/video-resolution/internal/pipeline"
+	"kiourin-studio/video-resolution/internal/probe"
+	"kiourin-studio/video-resolution/internal/scaler"
+)
+
+type (
+	// Options are the settings of a job, as the CLI flags set them.
+	Options = job.Options
+	// Result is the outcome of a job. Err and Code say what went wrong.
+	Result = job.Result
+	// Attempt is an encoder that failed before another one took over.
+	Attempt = job.Attempt
+	// Plan is what a dry run would do.
+	Plan = job.Plan
+	// Code says which step a job failed in.
+	Code = job.Code
+	// Update is a progress report from a running encode.
+	Update = job.Update
+
+	Profile     = encoder.Profile
+	Enhancement = pipeline.Enhancement
+	Policy      = output.Policy
+	Resolution  = scaler.Resolution
+	GPU         = ffmpeg.GPU
+	Failure     = ffmpeg.Failure
+	// Encoder is an FFmpeg encoder and its parameters.
+	Encoder = encoder.Config
+	// Capabilities lists FFmpeg's encoders, decoders, filters and
+	// hardware acceleration methods.
+	Capabilities = ffmpeg.Capabilities
+
+	// MediaInfo is what Probe reports about a file.
+	MediaInfo = probe.MediaInfo
+	Stream    = probe.Stream
+	Chapter   = probe.Chapter
+)
+
+// Scale modes for Options.Mode.
+const (
+	None = "none"
+	Down = "down"
+	Up   = "up"
+)
+
+const (
+	Low  = encoder.Low
+	Med  = encoder.Med
+	High = encoder.High
+)
+
+const (
+	Denoise = pipeline.Denoise
+	Deband  = pipeline.Deband
+	Sharpen = pipeline.Sharpen
+)
+
+const (
+	Always = output.Always
+	Never  = output.Never
+	Ask    = output.Ask
+	Rename = output.Rename
+)
+
+const (
+	NVIDIA = ffmpeg.NVIDIA
+	INTEL  = ffmpeg.INTEL
+	AMD    = ffmpeg.AMD
+	CPU    = ffmpeg.CPU
+)
+
+const (
+	MissingDependency  = job.MissingDependency
+	InputError         = job.InputError
+	ProbeError         = job.ProbeError
+	OutputError        = job.OutputError
+	EncoderUnavailable = job.EncoderUnavailable
+	EncodeFailed       = job.EncodeFailed
+	Cancelled          = job.Cancelled
+	VerifyFailed       = job.VerifyFailed
+)
+
+// Job is one video to encode. Output names the file to write; without it
+// the output goes to OutDir (or next to the input) under Options.Name or
+// the default name. GPU picks the backend, or leave it empty to resolve
+// Options.GPUMode when the job runs.
+type Job struct {
+	Input   string
+	Output  string
+	OutDir  string
+	Options Options
+	GPU     GPU
+	DryRun  bool
+
+	// Progress, when set, is called with every progress report FFmpeg
+	// makes. It runs on the job's goroutine, so it should not block.
+	Progress func(Update)
+	// Confirm is asked before replacing a file under the Ask policy.
+	Confirm func(path string) bool
+	// Logger gets the job's messages. They are dropped when it is nil.
+	Logger *slog.Logger
+	// Emit, when set, gets the job's events as vr -json prints them:
+	// probe, plan, progress, fallback and result.
+	Emit func(event string, data any)
+	// Planned is called with the output path once it is settled.
+	Planned func(output string)
+
+	// Console shows the job's messages, and a progress line when Progress
+	// is nil, on vr's console the way the command line does, instead of
+	// on Logger. Prefix starts each line; jobs running side by side need
+	// one and then share a single progress line.
+	Console bool
+	Prefix  string
+}
+
+// Run probes, plans and encodes j. The error is Result.Err, returned as
+// well for convenience; Result.Code says which step failed. Cancelling
+// ctx stops FFmpeg and removes the partial output.
+func Run(ctx context.Context, j Job) (Result, error) {
+	if err := ffmpeg.Init(); err != nil {
+		r := Result{Input: j.Input, Err: fmt.Errorf("ffmpeg not found: %w", err), Code: MissingDependency}
+		return r, r.Err
+	}
+
+	opts := defaults(j.Options)
+	gpu := j.GPU
+	if gpu == "" {
+		detectCtx, cancel := opts.DetectContext(ctx)
+		gpu = ffmpeg.ResolveGPU(detectCtx, opts.GPUMode)
+		cancel()
+	}
+
+	log := &logger.Logger{Prefix: j.Prefix, Shared: j.Prefix != ""}
+	progress := j.Progress
+	if !j.Console {
+		log.Handler = slog.DiscardHandler
+		if j.Logger != nil {
+			log.Handler = j.Logger.Handler()
+		}
+		if progress == nil {
+			// A job without a Progress callback would draw a progress bar.
+			progress = func(Update) {}
+		}
+	}
+
+	r := job.Run(ctx, job.Job{
+		DryRun:   j.DryRun,
+		Input:    j.Input,
+		Output:   j.Output,
+		OutDir:   j.OutDir,
+		Log:      log,
+		Progress: progress,
+		Planned:  j.Planned,
+		Confirm:  j.Confirm,
+		Emit:     j.Emit,
+	}, gpu, opts)
+	return r, r.Err
+}
+
+// Init checks that ffmpeg and ffprobe are installed. Run checks as well;
+// Init lets a program fail early.
+func Init() error {
+	return ffmpeg.Init()
+}
+
+// ForceStop kills every FFmpeg process still running, for when cancelling
+// the context did not stop them soon enough.
+func ForceStop() {
+	ffmpeg.ForceStop()
+}
+
+func defaults(opts Options) Options {
+	if opts.Mode == "" {
+		opts.Mode = None
+	}
+	if opts.Profile == "" {
+		opts.Profile = Med
+	}
+	if opts.GPUMode == "" {
+		opts.GPUMode = "auto"
+	}
+	return opts
+}
+
+// Probe runs ffprobe once on path and returns its container, streams and
+// chapters. Bound ctx with a timeout for files on slow or unreliable
+// storage.
+func Probe(ctx context.Context, path string) (*MediaInfo, error) {
+	return probe.Probe(ctx, path)
+}
+
+// ParseProfile reads "low", "med" or "high"; anything else is Med.
+func ParseProfile(s string) Profile {
+	return encoder.ParseProfile(s)
+}
+
+// ParseEnhancements reads a comma-separated list like "denoise,sharpen".
+func ParseEnhancements(s string) ([]Enhancement, error) {
+	return pipeline.ParseEnhancements(s)
+}
+
+// ParsePolicy reads an overwrite policy: always, never, ask or rename.
+func ParsePolicy(s string) (Policy, error) {
+	return output.ParsePolicy(s)
+}
+
+// ValidateName checks an Options.Name template for unknown placeholders.
+func ValidateName(template string) error {
+	return output.Validate(template)
+}
+
+// Target is the size Run scales source to under opts, before it is fitted
+// to what the encoder can do.
+func Target(source Resolution, opts Options) Resolution {
+	return job.Target(source, defaults(opts))
+}
+
+// DetectGPU returns the best backend FFmpeg has an encoder for.
+func DetectGPU(ctx context.Context) GPU {
+	return ffmpeg.DetectGPU(ctx)
+}
+
+// ResolveGPU turns a GPU mode ("auto", "nvidia", "intel", "amd", "gpu",
+// "igpu" or "cpu") into the backend that serves it here, falling back to
+// the CPU.
+func ResolveGPU(ctx context.Context, mode string) GPU {
+	return ffmpeg.ResolveGPU(ctx, mode)
+}
+
+// AvailableGPUs lists the backends FFmpeg has encoders for, CPU last.
+func AvailableGPUs(ctx context.Context) []GPU {
+	return ffmpeg.GetAvailableGPUs(ctx)
+}
+
+// Caps returns what the ffmpeg on PATH was built with. It is read once and
+// cached.
+func Caps(ctx context.Context) (*Capabilities, error) {
+	return ffmpeg.Caps(ctx)
+}
+
+// EncoderFor returns the encoder Run would start with on gpu.
+func EncoderFor(ctx context.Context, gpu GPU, opts Options) Encoder {
+	opts = defaults(opts)
+	enc := encoder.For(ctx, gpu, opts.Profile)
+	if opts.Compress {
+		enc = encoder.ApplyCompression(enc, gpu, opts.Profile)
+	}
+	return enc
+}
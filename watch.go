@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"kiourin-studio/video-resolution/internal/logger"
+	"kiourin-studio/video-resolution/internal/watch"
+	"kiourin-studio/video-resolution/vr"
+)
+
+func runWatch(args []string) int {
+	opts, err := parseArgs(args)
+	if err != nil {
+		fmt.Printf("\nError: %v\n", err)
+		showHelp()
+		return exitUsage
+	}
+	if opts.output != "" {
+		fmt.Println("\nError: -o cannot be used with watch, use -outdir and -name")
+		showHelp()
+		return exitUsage
+	}
+	if len(opts.inputs) != 1 {
+		fmt.Println("\nError: watch needs exactly one directory")
+		showHelp()
+		return exitUsage
+	}
+
+	dir := opts.inputs[0]
+	parent := filepath.Dir(filepath.Clean(dir))
+	if opts.outDir == "" {
+		opts.outDir = filepath.Join(parent, "done")
+	}
+	if opts.archiveDir == "" {
+		opts.archiveDir = filepath.Join(parent, "archive")
+	}
+	if opts.failedDir == "" {
+		opts.failedDir = filepath.Join(parent, "failed")
+	}
+
+	closeLog, ok := setOutput(opts)
+	if !ok {
+		return exitUsage
+	}
+	defer closeLog()
+	if !initEngine() {
+		return exitDependency
+	}
+	detectedGPU := selectGPU(opts)
+	jobOpts := opts.jobOptions()
+
+	logger.Info("Watch", fmt.Sprintf("Watching %s (every %s)", dir, opts.interval))
+	logger.Info("Watch", fmt.Sprintf("Output: %s, archive: %s, failed: %s", opts.outDir, opts.archiveDir, opts.failedDir))
+
+	ctx := interruptContext()
+	err = watch.Run(ctx, watch.Config{
+		Dir:        dir,
+		ArchiveDir: opts.archiveDir,
+		FailedDir:  opts.failedDir,
+		Interval:   opts.interval,
+		Match:      vr.IsVideo,
+		Process: func(path string) error {
+			_, err := vr.Run(ctx, vr.Job{
+				Input:   path,
+				OutDir:  opts.outDir,
+				Options: jobOpts,
+				GPU:     detectedGPU,
+				Emit:    emitFor(path),
+				Console: true,
+			})
+			return err
+		},
+	})
+	if err != nil {
+		logger.Error("Error", fmt.Sprintf("Watch stopped: %v", err))
+		return exitFailed
+	}
+	logger.Info("Watch", "Stopped")
+	return exitCancelled
+}
+
+func parseInterval(v string) (time.Duration, error) {
+	d, err := time.ParseDuration(v)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid -interval: %s", v)
+	}
+	return d, nil
+}